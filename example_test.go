@@ -0,0 +1,80 @@
+package uritemplate_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aksamyt/uritemplate"
+	"github.com/aksamyt/uritemplate/pkg/escape"
+)
+
+var userPosts = uritemplate.MustParse("/users/{id}/posts{?page,tags*}")
+
+func Example() {
+	url, err := userPosts.Expand(map[string]interface{}{
+		"id":   42,
+		"page": 2,
+		"tags": []string{"go", "uri templates"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(url)
+	// Output:
+	// /users/42/posts?page=2&tags=go&tags=uri%20templates
+}
+
+func ExampleTemplate_Expand() {
+	type Search struct {
+		Query string `uri:"q"`
+		Lang  string `uri:"lang"`
+	}
+	search := uritemplate.MustParse("https://example.com/search{?q,lang}")
+	url, _ := search.Expand(Search{Query: "café crème", Lang: "fr"})
+	fmt.Println(url)
+	// Output:
+	// https://example.com/search?q=caf%C3%A9%20cr%C3%A8me&lang=fr
+}
+
+func ExampleTemplate_ExpandTo() {
+	file := uritemplate.MustParse("{/dirs*}/{name}{.ext}")
+	file.ExpandTo(os.Stdout, map[string]interface{}{
+		"dirs": []string{"home", "gontrand"},
+		"name": "notes",
+		"ext":  "txt",
+	})
+	fmt.Println()
+	// Output:
+	// /home/gontrand/notes.txt
+}
+
+func ExampleTemplate_Match() {
+	search := uritemplate.MustParse("/users/{id}/search{?q,lang}")
+	values, ok := search.Match("/users/42/search?q=caf%C3%A9%20cr%C3%A8me")
+	fmt.Println(ok, values["id"], values["q"])
+	_, ok = search.Match("/users/42/posts")
+	fmt.Println(ok)
+	// Output:
+	// true 42 café crème
+	// false
+}
+
+func ExampleTemplate_MatchWith() {
+	search := uritemplate.MustParse("/search{?q}")
+	values, _ := search.Match("/search?q=a+b")
+	fmt.Println(values["q"])
+	values, _ = search.MatchWith("/search?q=a+b", map[byte]escape.Decoder{'?': escape.UnescapePlus})
+	fmt.Println(values["q"])
+	// Output:
+	// a+b
+	// a b
+}
+
+func ExampleParse() {
+	_, err := uritemplate.Parse("/users/{id")
+	fmt.Println(err)
+	// Output:
+	// error at col 11: expected '}', got EOF
+	// /users/{id
+	//           ^
+}
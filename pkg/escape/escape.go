@@ -11,6 +11,8 @@
 // RFC6570.
 package escape
 
+import "strings"
+
 const upperhex = "0123456789ABCDEF"
 const truth = "" +
 	"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA" +
@@ -40,41 +42,259 @@ const (
 )
 
 // Escape escapes the string, replacing masked characters with %XX sequences
-// as needed.
+// as needed. s itself is returned, without allocating, if nothing needs
+// escaping.
 func Escape(s string, mask byte) string {
-	hexCount := 0
+	first := 0
+	for first < len(s) && truth[s[first]]&mask == 0 {
+		first++
+	}
+	if first == len(s) {
+		return s
+	}
+
+	// a single pass from the first character to escape, into a buffer with
+	// room for a few escapes, which grows if there are more
+	var buf [64]byte
+	t := buf[:0]
+	if n := len(s) + len(s)/2; n > len(buf) {
+		t = make([]byte, 0, n)
+	}
+	t = append(t, s[:first]...)
+	return string(AppendEscape(t, s[first:], mask))
+}
+
+// AppendEscape appends the escaped form of s to dst and returns the extended
+// buffer. It escapes exactly like Escape, but lets the caller reuse a buffer
+// across many values.
+func AppendEscape(dst []byte, s string, mask byte) []byte {
 	for i := 0; i < len(s); i++ {
 		c := s[i]
 		if truth[c]&mask != 0 {
-			hexCount++
+			dst = append(dst, '%', upperhex[c>>4], upperhex[c&0xF])
+		} else {
+			dst = append(dst, c)
 		}
 	}
+	return dst
+}
 
-	if hexCount == 0 {
-		return s
+// AppendEscapeKeepEncoded is like AppendEscape, but copies the %XX sequences
+// of s as they are instead of escaping their '%'. This is how RFC6570 lets
+// pct-encoded triplets through in reserved expansion, so that a value
+// already encoded is not encoded twice. A '%' that does not start such a
+// sequence is escaped as usual.
+func AppendEscapeKeepEncoded(dst []byte, s string, mask byte) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && unhex(s[i+1]) >= 0 && unhex(s[i+2]) >= 0:
+			dst = append(dst, s[i:i+3]...)
+			i += 2
+		case truth[c]&mask != 0:
+			dst = append(dst, '%', upperhex[c>>4], upperhex[c&0xF])
+		default:
+			dst = append(dst, c)
+		}
 	}
+	return dst
+}
 
-	var buf [64]byte
-	var t []byte
+// EscapeKeepEncoded is like Escape, but keeps the %XX sequences of s, as
+// AppendEscapeKeepEncoded does.
+func EscapeKeepEncoded(s string, mask byte) string {
+	for i := 0; i < len(s); i++ {
+		if truth[s[i]]&mask != 0 {
+			return string(AppendEscapeKeepEncoded(make([]byte, 0, len(s)+8), s, mask))
+		}
+	}
+	return s
+}
 
-	required := len(s) + 2*hexCount
-	if required <= len(buf) {
-		t = buf[:required]
-	} else {
-		t = make([]byte, required)
+// The characters that RFC3986 allows as is in each URI component, besides
+// the unreserved ones:
+//
+//	pchar    = unreserved / pct-encoded / sub-delims / ":" / "@"
+//	query    = *( pchar / "/" / "?" )
+//	fragment = *( pchar / "/" / "?" )
+//
+// The query components are keys and values: they leave out the delimiters
+// of application/x-www-form-urlencoded, '&' and '=', as well as '+', which
+// it reads as a space.
+const subDelims = "!$&'()*+,;="
+
+var (
+	pathSegmentTable    = componentTable(subDelims+":@", "")
+	queryComponentTable = componentTable(subDelims+":@/?", "&=+")
+	fragmentTable       = componentTable(subDelims+":@/?", "")
+)
+
+// componentTable returns the table of the bytes to escape in a component
+// that allows the unreserved characters, and those of allowed that are not
+// in except.
+func componentTable(allowed, except string) (t [256]bool) {
+	for c := 0; c < 256; c++ {
+		t[c] = truth[c]&Unreserved == 0
+	}
+	for i := 0; i < len(allowed); i++ {
+		t[allowed[i]] = strings.IndexByte(except, allowed[i]) >= 0
 	}
+	return
+}
+
+// escapeTable escapes the bytes of s that table marks, like Escape.
+func escapeTable(s string, table *[256]bool) string {
+	for i := 0; i < len(s); i++ {
+		if table[s[i]] {
+			t := append(make([]byte, 0, len(s)+8), s[:i]...)
+			for ; i < len(s); i++ {
+				if c := s[i]; table[c] {
+					t = append(t, '%', upperhex[c>>4], upperhex[c&0xF])
+				} else {
+					t = append(t, c)
+				}
+			}
+			return string(t)
+		}
+	}
+	return s
+}
+
+// EscapePathSegment escapes s for a segment of the path of a URI, as
+// defined by RFC3986: everything but the unreserved characters, the
+// sub-delims, ':' and '@' is percent-encoded, including '/'.
+func EscapePathSegment(s string) string {
+	return escapeTable(s, &pathSegmentTable)
+}
 
-	for i, j := 0, 0; i < len(s); i++ {
+// EscapeQueryComponent escapes s for a key or a value of the query of a URI:
+// everything that RFC3986 does not allow in a query is percent-encoded, and
+// so are '&', '=' and '+', which separate or mean something else in query
+// parameters. Spaces are encoded as "%20", which url.QueryUnescape reads
+// back as well as '+'.
+func EscapeQueryComponent(s string) string {
+	return escapeTable(s, &queryComponentTable)
+}
+
+// EscapeFragment escapes s for the fragment of a URI, as defined by
+// RFC3986: everything but the unreserved characters, the sub-delims, ':',
+// '@', '/' and '?' is percent-encoded.
+func EscapeFragment(s string) string {
+	return escapeTable(s, &fragmentTable)
+}
+
+// Prefix returns the first n characters of s, as counted by RFC6570: each
+// Unicode code point is one character, so a multibyte sequence is never cut
+// in half. Bytes that are not valid UTF-8 count as one character each.
+func Prefix(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// PathSegments escapes each segment and joins them with '/'.
+//
+// Segments are escaped with Disallowed|Reserved, so a '/' inside a segment is
+// encoded and never splits it. This is what "{/segs*}" expands to, without
+// the leading slash and without building a template.
+func PathSegments(segs []string) string {
+	var buf []byte
+	for i, seg := range segs {
+		if i > 0 {
+			buf = append(buf, '/')
+		}
+		buf = AppendEscape(buf, seg, Disallowed|Reserved)
+	}
+	return string(buf)
+}
+
+// InvalidEscapeError is returned by Unescape for a '%' that does not start
+// a %XX sequence.
+type InvalidEscapeError string
+
+func (e InvalidEscapeError) Error() string {
+	return "invalid percent-encoding " + string(e)
+}
+
+// unhex returns the value of the hexadecimal digit c, or -1.
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
+
+// unescape decodes the %XX sequences of s. A '%' not followed by two
+// hexadecimal digits is kept as is if lenient, or makes it fail.
+func unescape(s string, lenient bool) (string, error) {
+	var t []byte
+	for i := 0; i < len(s); i++ {
 		c := s[i]
-		if truth[c]&mask != 0 {
-			t[j] = '%'
-			t[j+1] = upperhex[c>>4]
-			t[j+2] = upperhex[c&0xF]
-			j += 3
-		} else {
-			t[j] = c
-			j++
+		if c == '%' {
+			if i+2 < len(s) && unhex(s[i+1]) >= 0 && unhex(s[i+2]) >= 0 {
+				if t == nil {
+					t = append(make([]byte, 0, len(s)), s[:i]...)
+				}
+				t = append(t, byte(unhex(s[i+1])<<4|unhex(s[i+2])))
+				i += 2
+				continue
+			}
+			if !lenient {
+				end := i + 3
+				if end > len(s) {
+					end = len(s)
+				}
+				return "", InvalidEscapeError(s[i:end])
+			}
+		}
+		if t != nil {
+			t = append(t, c)
 		}
 	}
-	return string(t)
+	if t == nil {
+		return s, nil
+	}
+	return string(t), nil
+}
+
+// Unescape decodes every %XX sequence of s. It is the inverse of Escape for
+// the masks that include Disallowed, which '%' belongs to. It fails with an
+// InvalidEscapeError on a '%' that does not start such a sequence.
+func Unescape(s string) (string, error) {
+	return unescape(s, false)
+}
+
+// A Decoder reverses the escaping of a value, such as Unescape. Tools that
+// read values back from URLs, such as the matchers of the execute package,
+// take one for each operator, where the conventions differ: form-encoded
+// queries write spaces as '+', which UnescapePlus decodes.
+type Decoder func(s string) (string, error)
+
+// UnescapePlus is like Unescape, but decodes '+' as a space first, like
+// the application/x-www-form-urlencoded queries of HTML forms write them.
+// An encoded "%2B" is still a '+'.
+func UnescapePlus(s string) (string, error) {
+	return Unescape(strings.ReplaceAll(s, "+", " "))
+}
+
+var (
+	_ Decoder = Unescape
+	_ Decoder = UnescapePlus
+)
+
+// UnescapeLenient is like Unescape, but keeps the stray '%' as they are.
+// It suits values expanded with the '+' and '#' operators, where reserved
+// characters, possibly written by hand, are let through.
+func UnescapeLenient(s string) string {
+	t, _ := unescape(s, true)
+	return t
 }
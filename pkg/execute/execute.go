@@ -12,21 +12,68 @@ package execute
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/lexer"
 	"github.com/aksamyt/uritemplate/pkg/parser"
 )
 
 type exprWriter struct {
-	buf    bytes.Buffer  // used to do a single write and to implement some operator’s quirks
-	data   reflect.Value // the original data passed to Execute
-	expr   *parser.Expr  // the expression being printed
-	i      int           // the number of defined variables written
-	varsep byte          // the variable separator defined by the operator
-	mask   byte          // the mask given to escape.Escape defined by the operator
+	buf       *bytes.Buffer          // used to do a single write and to implement some operator’s quirks
+	scratch   *scratch               // where buf, opts and expr live
+	data      reflect.Value          // the original data passed to Execute
+	strs      map[string]string      // data, if it is a map[string]string
+	ifaces    map[string]interface{} // data, if it is a map[string]interface{}
+	expr      *parser.Expr           // the expression being printed
+	variable  *parser.Var            // the variable being printed
+	opts      *Options               // the options given to ExecuteWith
+	err       error                  // the first error encountered
+	i         int                    // the number of defined variables written
+	fields    [][]int                // the compiled field indices of the variables, if any
+	field     []int                  // the compiled field index of the current variable, if any
+	ftype     reflect.Type           // the struct type the field indices are valid for
+	report    *Report                // where to record how variables resolved, if not nil
+	resolver  Resolver               // data, if it resolves its own variables
+	pending   []parser.Var           // the undefined variables to write back, in partial mode
+	part      int                    // the index of the part being written
+	written   int                    // the number of bytes written before the part
+	steps     *[]Step                // where to record the steps of the expansion, if not nil
+	resolved  map[string]resolved    // the values shared by the templates of a Set, if any
+	depth     int                    // the nesting depth of the value being formatted
+	escapeAll bool                   // whether Reserved values are escaped like the others
+	operator
+}
+
+// fail records err, unless an error was already recorded.
+func (e *exprWriter) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// undefined is called for each variable that resolved to undefined.
+func (e *exprWriter) undefined(v *parser.Var) {
+	if e.report != nil {
+		e.report.undefined(v)
+	}
+	if e.opts.Partial {
+		e.pending = append(e.pending, *v)
+		return
+	}
+	if e.expr.Op == '?' || e.expr.Op == '&' {
+		return
+	}
+	if e.opts.RequirePathVars {
+		e.fail(ResolveError{Path: v.ID})
+	} else if e.opts.UndefinedPlaceholder != "" {
+		placeholder := *v
+		placeholder.Mod = 0
+		e.writeScalar(&placeholder, e.opts.UndefinedPlaceholder)
+	}
 }
 
 func (e *exprWriter) writeListSeparator() {
@@ -39,27 +86,134 @@ func (e *exprWriter) writeVariableSeparator() {
 	}
 }
 
-// formatValue is where the Prefix modifier is checked for.
-func (e *exprWriter) formatValue(value reflect.Value, mod parser.Mod) {
-	unescaped := fmt.Sprint(value)
+// formatString is where the Prefix modifier is checked for.
+func (e *exprWriter) formatString(unescaped string, mod parser.Mod) {
 	if mod&parser.ModPrefix != 0 {
-		if l := int(mod ^ parser.ModPrefix); l < len(unescaped) {
-			unescaped = unescaped[:l]
+		unescaped = escape.Prefix(unescaped, mod.Length())
+	}
+	if validate := e.opts.ValidateValue; validate != nil {
+		if err := validate(e.variable, e.expr.Op, unescaped); err != nil {
+			e.fail(InvalidValueError{Path: e.variable.ID, Value: unescaped, Err: err})
+			return
+		}
+	}
+	e.writeEscaped(unescaped, e.mask)
+}
+
+// writeEscaped writes s escaped with mask. It is escaped into the scratch
+// buffer, rather than into a new string. Masks that let the reserved
+// characters through, like those of '+' and '#', let the pct-encoded
+// triplets of s through too, as RFC 6570 allows in reserved expansion.
+func (e *exprWriter) writeEscaped(s string, mask byte) {
+	if mask&escape.Reserved == 0 {
+		e.scratch.esc = escape.AppendEscapeKeepEncoded(e.scratch.esc[:0], s, mask)
+	} else {
+		e.scratch.esc = escape.AppendEscape(e.scratch.esc[:0], s, mask)
+	}
+	e.buf.Write(e.scratch.esc)
+}
+
+// maxNesting is the depth of nested composite values beyond which they are
+// left out, or fail with a LimitError in strict mode, which only cyclic data
+// reaches in practice.
+const maxNesting = 32
+
+// formatValue writes a value as a scalar. Lists and associative arrays
+// nested in a composite value have their items joined with commas, like a
+// composite value that is not exploded, up to maxNesting levels deep.
+func (e *exprWriter) formatValue(value reflect.Value, mod parser.Mod) {
+	switch {
+	case (isList(value) || isAssociative(value)) && e.depth >= maxNesting:
+		if e.opts.Strict {
+			e.fail(LimitError{Path: e.variable.ID, Limit: maxNesting})
+		}
+		return
+	case isList(value):
+		e.depth++
+		defer func() { e.depth-- }()
+		for i, item := range listItems(value) {
+			if i > 0 {
+				e.writeListSeparator()
+			}
+			e.formatValue(item, mod)
 		}
+		return
+	case isAssociative(value):
+		e.depth++
+		defer func() { e.depth-- }()
+		for i, p := range associativePairs(value) {
+			if i > 0 {
+				e.writeListSeparator()
+			}
+			e.writeEscaped(p.key, e.mask)
+			e.writeListSeparator()
+			e.formatValue(p.value, 0)
+		}
+		return
+	}
+	if value.Type() == reservedType {
+		defer e.keepReserved()()
+	}
+	e.formatString(e.stringify(value), mod)
+}
+
+// stringify is like the stringify function, but in strict mode a failing
+// MarshalText method is a FormatError.
+func (e *exprWriter) stringify(value reflect.Value) string {
+	if e.opts.Strict {
+		if err := marshalError(value); err != nil {
+			e.fail(FormatError{Path: e.variable.ID, Cause: err})
+		}
+	}
+	return stringify(value)
+}
+
+// keepReserved stops the escaping of reserved characters, and returns the
+// function restoring the mask of the operator. It does nothing if the
+// writer escapes every value alike.
+func (e *exprWriter) keepReserved() func() {
+	if e.escapeAll {
+		return func() {}
 	}
-	e.buf.WriteString(escape.Escape(unescaped, e.mask))
+	mask := e.mask
+	e.mask &^= escape.Reserved
+	return func() { e.mask = mask }
 }
 
+// formatList writes the items of a list value. The prefix modifier does not
+// apply to them, see strictPrefix.
+//
 // Increments the variable counter.
-func (e *exprWriter) formatList(value reflect.Value, mod parser.Mod) {
-	if value.Len() > 0 {
-		e.formatValue(value.Index(0), mod)
-		for i := 1; i < value.Len(); i++ {
+func (e *exprWriter) formatList(items []reflect.Value) {
+	for i, item := range items {
+		if i > 0 {
 			e.writeListSeparator()
-			e.formatValue(value.Index(i), mod)
 		}
-		e.i++
+		e.formatValue(item, 0)
+		if e.overflows() {
+			return
+		}
 	}
+	e.i++
+}
+
+// formatPairs writes the pairs of an associative value as a flat list of
+// keys and values.
+//
+// Increments the variable counter.
+func (e *exprWriter) formatPairs(pairs []pair) {
+	for i, p := range pairs {
+		if i > 0 {
+			e.writeListSeparator()
+		}
+		e.writeEscaped(p.key, e.mask)
+		e.writeListSeparator()
+		e.formatValue(p.value, 0)
+		if e.overflows() {
+			return
+		}
+	}
+	e.i++
 }
 
 // Increments the variable counter.
@@ -68,180 +222,585 @@ func (e *exprWriter) writeVariableValue(value reflect.Value, mod parser.Mod) {
 	e.i++
 }
 
-func (e *exprWriter) writeValueAsKey(value reflect.Value) {
-	e.formatValue(value, 0)
+func (e *exprWriter) writeKey(key string) {
+	e.writeEscaped(key, e.mask)
 	e.buf.WriteByte('=')
 }
 
 func (e *exprWriter) writeVariableKey(v *parser.Var) {
-	e.buf.WriteString(v.ID[len(v.ID)-1])
+	key := v.ID[len(v.ID)-1]
+	if e.opts.EscapeKeys {
+		key = escape.EscapeKeepEncoded(key, e.mask)
+	}
+	e.buf.WriteString(key)
 	e.buf.WriteByte('=')
 }
 
-// writeKvVariable writes a variable’s value in a key/value context.
-// Exploded iterable values are treated as if they were a collection of values
-// registered under the same key, which is the variable’s name.
-func (e *exprWriter) writeKvVariable(v *parser.Var) {
-	value := findVariableValue(e.data, v)
-
-	// value was probably a nil interface{}, treat it as undef
-	if !value.IsValid() {
+// writeVariable writes a variable’s value, either in a key/value context for
+// the named operators, or in a list context for the others.
+//
+// In a key/value context, exploded lists are treated as if they were a
+// collection of values registered under the same key, which is the
+// variable’s name. Likewise, the lists held by an exploded associative array
+// repeat their key for each of their items.
+//
+// As an extension to RFC 6570, the structs and maps held by an exploded list
+// are expanded like exploded associative arrays, one after the other, so
+// that "{?items*}" with two items gives "?name=a&value=1&name=b&value=2".
+// Lists of them that are not exploded are an error in strict mode.
+//
+// Missing values, nil values, empty lists or associative arrays, channels and
+// functions are all undefined, and write nothing at all. A nil value that a
+// Resolver reported as defined writes nothing either, but is not treated as
+// undefined. Pointers are followed: a pointer to an empty string is defined
+// and empty, keeping its key under the named operators, unlike a nil one.
+func (e *exprWriter) writeVariable(v *parser.Var) {
+	format := e.formatter(v)
+	if format == nil {
+		if s, ok := e.lookupString(v); ok && !(e.opts.OmitZero && s == "") {
+			e.writeScalar(v, s)
+			return
+		}
+	}
+	value, found := e.resolve(v, format)
+	if e.err != nil {
 		return
 	}
+	if e.opts.OnUndefined != nil && isUndefined(value, found) {
+		if x, ok := e.opts.OnUndefined(v.ID, e.expr); ok {
+			value, found = reflect.ValueOf(x), true
+			dereference(&value)
+		}
+	}
+	explode := v.Mod&parser.ModExplode != 0
+	if value.IsValid() && value.Type() == reservedType {
+		defer e.keepReserved()()
+	}
 
-	switch value.Kind() {
-	case reflect.Slice:
-		if v.Mod&parser.ModExplode == 0 {
+	switch {
+	case format != nil && value.IsValid() && value.CanInterface():
+		e.writeScalar(v, format(value.Interface()))
+	case isList(value):
+		items := listItems(value)
+		if len(items) == 0 {
+			e.undefined(v)
+		} else if e.strictPrefix(v) {
+			return
+		} else if !explode {
+			if e.opts.Strict && hasAssociative(items) {
+				e.fail(NestedCompositeError{Path: v.ID})
+				return
+			}
 			e.writeVariableSeparator()
-			e.writeVariableKey(v)
-			e.formatList(value, v.Mod)
+			if e.named {
+				e.writeVariableKey(v)
+			}
+			e.formatList(items)
 		} else {
 			// treat each child as a separate variable
-			for i := 0; i < value.Len(); i++ {
+			for _, item := range items {
+				if isAssociative(item) {
+					// extension: associative arrays are expanded as
+					// their own pairs
+					e.writeExplodedPairs(associativePairs(item))
+					if e.overflows() {
+						return
+					}
+					continue
+				}
 				e.writeVariableSeparator()
-				e.writeVariableKey(v)
-				e.writeVariableValue(value.Index(i), 0)
+				if e.named {
+					e.writeVariableKey(v)
+				}
+				n := e.buf.Len()
+				e.writeVariableValue(item, 0)
+				e.trimEmptyKey(n)
+				if e.overflows() {
+					return
+				}
 			}
 		}
-	case reflect.Map:
-		if v.Mod&parser.ModExplode == 0 {
-			e.writeVariableKey(v)
-			for it := value.MapRange(); it.Next(); {
-				if e.i > 0 {
-					e.writeListSeparator()
-				}
-				e.writeVariableValue(it.Key(), 0)
-				e.writeListSeparator()
-				e.writeVariableValue(it.Value(), 0)
+	case isAssociative(value):
+		pairs := associativePairs(value)
+		if len(pairs) == 0 {
+			e.undefined(v)
+		} else if e.strictPrefix(v) {
+			return
+		} else if !explode {
+			e.writeVariableSeparator()
+			if e.named {
+				e.writeVariableKey(v)
 			}
+			e.formatPairs(pairs)
 		} else {
-			for it := value.MapRange(); it.Next(); {
-				e.writeVariableSeparator()
-				e.writeValueAsKey(it.Key())
-				e.writeVariableValue(it.Value(), 0)
-			}
+			e.writeExplodedPairs(pairs)
 		}
+	case !value.IsValid():
+		if !found {
+			e.undefined(v)
+		}
+	case isOpaque(value):
+		if e.opts.Strict {
+			e.fail(UnsupportedValueError{Path: v.ID, Kind: value.Kind()})
+			return
+		}
+		e.undefined(v)
+	case explode && e.opts.Strict && isBytes(value):
+		e.fail(ModOnScalarError{Path: v.ID, Mod: v.Mod})
 	default:
-		e.writeVariableSeparator()
-		e.writeVariableKey(v)
-		lenBefore := e.buf.Len()
-		e.writeVariableValue(value, v.Mod)
-		// path operator keys must not have an equals sign if the
-		// variable is visibly empty
-		if e.expr.Op == ';' && e.buf.Len() == lenBefore {
-			e.buf.Truncate(lenBefore - 1)
+		e.writeScalar(v, e.stringify(value))
+	}
+}
+
+// writeExplodedPairs writes the pairs of an exploded associative value as
+// key=value variables.
+func (e *exprWriter) writeExplodedPairs(pairs []pair) {
+	for _, p := range pairs {
+		if isList(p.value) {
+			// repeat the key for each item, like an exploded list under
+			// a named operator
+			for _, item := range listItems(p.value) {
+				e.writePair(p.key, item)
+			}
+		} else {
+			e.writePair(p.key, p.value)
+		}
+		if e.overflows() {
+			return
 		}
 	}
 }
 
-// writeListVariable writes a variable’s value in a list context.
-func (e *exprWriter) writeListVariable(v *parser.Var) {
-	value := findVariableValue(e.data, v)
+// writePair writes a key and its value as a variable.
+//
+// Increments the variable counter.
+func (e *exprWriter) writePair(key string, value reflect.Value) {
+	e.writeVariableSeparator()
+	e.writeKey(key)
+	n := e.buf.Len()
+	e.writeVariableValue(value, 0)
+	e.trimEmptyKey(n)
+}
 
-	// value was probably a nil interface{}, treat it as undef
-	if !value.IsValid() {
-		return
+// trimEmptyKey removes the equals sign written at n-1, before a value that
+// turned out empty, under the ';' operator, whose keys of empty values stand
+// alone.
+func (e *exprWriter) trimEmptyKey(n int) {
+	if e.expr.Op == ';' && e.buf.Len() == n {
+		e.buf.Truncate(n - 1)
 	}
+}
 
-	switch value.Kind() {
-	case reflect.Slice:
-		if v.Mod&parser.ModExplode == 0 {
-			e.formatList(value, v.Mod)
-		} else {
-			// treat each child as a separate variable
-			for i := 0; i < value.Len(); i++ {
-				e.writeVariableSeparator()
-				e.writeVariableValue(value.Index(i), 0)
-			}
-		}
-	case reflect.Map:
-		if v.Mod&parser.ModExplode == 0 {
-			for it := value.MapRange(); it.Next(); {
-				if e.i > 0 {
-					e.writeListSeparator()
-				}
-				e.writeVariableValue(it.Key(), 0)
-				e.writeListSeparator()
-				e.writeVariableValue(it.Value(), 0)
-			}
-		} else {
-			for it := value.MapRange(); it.Next(); {
-				e.writeVariableSeparator()
-				e.writeValueAsKey(it.Key())
-				e.writeVariableValue(it.Value(), 0)
-			}
+// strictPrefix fails and returns true if v, a composite value, has a prefix
+// modifier in strict mode.
+//
+// Otherwise, the prefix is ignored, and the items of lists and associative
+// arrays are expanded in full, as RFC 6570 does not define it for them.
+func (e *exprWriter) strictPrefix(v *parser.Var) bool {
+	if e.opts.Strict && v.Mod&parser.ModPrefix != 0 {
+		e.fail(ModOnCompositeError{Path: v.ID, Mod: v.Mod})
+		return true
+	}
+	return false
+}
+
+// overflows fails and returns true if the output would exceed
+// Options.MaxLen with the expression written so far.
+func (e *exprWriter) overflows() bool {
+	if e.opts.MaxLen > 0 && e.written+e.buf.Len() > e.opts.MaxLen {
+		e.fail(TooLongError{Limit: e.opts.MaxLen, Part: e.part})
+		return true
+	}
+	return false
+}
+
+// formatter returns the custom formatter registered for v, if any.
+func (e *exprWriter) formatter(v *parser.Var) func(interface{}) string {
+	if e.opts.Formats == nil {
+		return nil
+	}
+	return e.opts.Formats[strings.Join(v.ID, ".")]
+}
+
+// writeScalar writes the rendered value of a scalar variable.
+//
+// Increments the variable counter.
+func (e *exprWriter) writeScalar(v *parser.Var, s string) {
+	if s == "" && e.named && e.opts.DropEmptyQueryValues {
+		return
+	}
+	if s == "" && e.report != nil {
+		e.report.empty(v)
+	}
+	e.writeVariableSeparator()
+	if e.named {
+		e.writeVariableKey(v)
+		// path operator keys must not have an equals sign if the
+		// variable is visibly empty
+		if e.expr.Op == ';' && s == "" {
+			e.buf.Truncate(e.buf.Len() - 1)
 		}
-	default:
-		e.writeVariableSeparator()
-		e.writeVariableValue(value, v.Mod)
 	}
+	e.formatString(s, v.Mod)
+	e.i++
 }
 
-// writeExpr initializes the state of its receiver and calls the right write
-// function depending on the context given by the operator.
-func (e *exprWriter) writeExpr() {
-	switch e.expr.Op {
-	case '+', '#':
-		e.varsep, e.mask = ',', escape.Disallowed
+// operator holds the expansion rules that depend on an expression’s
+// operator.
+type operator struct {
+	sign   byte // written before the first variable, 0 if none
+	varsep byte // the variable separator
+	mask   byte // the mask given to escape.Escape
+	named  bool // whether variables are written as key/value pairs
+}
+
+func operatorOf(op byte) operator {
+	switch op {
+	case '+':
+		return operator{0, ',', escape.Disallowed, false}
+	case '#':
+		return operator{'#', ',', escape.Disallowed, false}
 	case '.':
-		e.varsep, e.mask = '.', escape.Disallowed|escape.Reserved
+		return operator{'.', '.', escape.Disallowed | escape.Reserved, false}
 	case '/':
-		e.varsep, e.mask = '/', escape.Disallowed|escape.Reserved
+		return operator{'/', '/', escape.Disallowed | escape.Reserved, false}
 	case ';':
-		e.varsep, e.mask = ';', escape.Disallowed|escape.Reserved
-	case '?', '&':
-		e.varsep, e.mask = '&', escape.Disallowed|escape.Reserved
-	default:
-		e.varsep, e.mask = ',', escape.Disallowed|escape.Reserved
+		return operator{';', ';', escape.Disallowed | escape.Reserved, true}
+	case '?':
+		return operator{'?', '&', escape.Disallowed | escape.Reserved, true}
+	case '&':
+		return operator{'&', '&', escape.Disallowed | escape.Reserved, true}
+	}
+	if ext, ok := extensionOps.Load(op); ok {
+		return ext.(operator)
+	}
+	return operator{0, ',', escape.Disallowed | escape.Reserved, false}
+}
+
+// OperatorMask returns the variable separator of the expressions with the
+// operator op, and the mask given to escape.Escape for their values, 0
+// being the operator of the expressions without one. Other tools working on
+// templates, such as matchers, can rely on it to agree with the expansion.
+// Operators registered with RegisterOperator have their own rules, and the
+// unknown ones those of the expressions without an operator.
+func OperatorMask(op byte) (varsep byte, mask byte) {
+	rules := operatorOf(op)
+	return rules.varsep, rules.mask
+}
+
+// extensionOps maps the operators registered with RegisterOperator to their
+// rules.
+var extensionOps sync.Map
+
+// RegisterOperator adds op, one of lexer.ExtensionOps, to the operators of
+// the templates parsed afterwards. Its expressions separate their variables
+// with varsep, escape their values with mask, given to escape.Escape, and
+// begin with op itself if writeSign is set. Like with the operators
+// without names, lists are joined with commas and the keys of exploded
+// associative arrays are followed by '='.
+//
+// The built-in operators cannot be changed: RegisterOperator panics if op is
+// not one of lexer.ExtensionOps. It is meant to be called during
+// initialization.
+func RegisterOperator(op byte, varsep byte, mask byte, writeSign bool) {
+	lexer.RegisterOperator(op)
+	ext := operator{0, varsep, mask, false}
+	if writeSign {
+		ext.sign = op
 	}
+	extensionOps.Store(op, ext)
+}
 
-	// almost all operators have their sign written
-	if e.expr.Op != 0 && e.expr.Op != '+' {
-		e.buf.WriteByte(e.expr.Op)
+// writeExpr writes the expression, following the rules of the operator
+// the receiver was initialized with.
+func (e *exprWriter) writeExpr() {
+	if e.sign != 0 {
+		e.buf.WriteByte(e.sign)
+		e.explain(StepSign, nil, 0, e.i)
 	}
 
-	switch e.expr.Op {
-	case ';', '?', '&':
-		for i := range e.expr.Vars {
-			e.writeKvVariable(&e.expr.Vars[i])
+	for i := range e.expr.Vars {
+		e.field = nil
+		if e.fields != nil {
+			e.field = e.fields[i]
 		}
-	default:
-		for i := range e.expr.Vars {
-			e.writeListVariable(&e.expr.Vars[i])
+		e.variable = &e.expr.Vars[i]
+		n, defined := e.buf.Len(), e.i
+		e.writeVariable(e.variable)
+		e.explain(StepVariable, e.variable, n, defined)
+		if e.err != nil || e.overflows() {
+			return
 		}
-		// some operators require at least one defined variable
-		if e.i == 0 && (e.expr.Op == '#' || e.expr.Op == '.') {
+	}
+
+	// an expression without any defined variable expands to nothing, not
+	// even its operator’s sign
+	if e.i == 0 {
+		e.buf.Reset()
+		e.unexplainSign()
+	}
+
+	if len(e.pending) > 0 {
+		e.writePending()
+	}
+}
+
+// writePending writes the undefined variables back as an expression, for
+// partial expansion. The values of the operators that join their variables
+// with commas cannot be split across two expressions without losing the
+// comma between them, so those expressions are written back whole.
+func (e *exprWriter) writePending() {
+	op := e.expr.Op
+	if e.i > 0 {
+		if e.varsep == ',' {
 			e.buf.Reset()
+			e.buf.WriteString(e.expr.String())
+			return
+		}
+		// the sign was already written
+		if op == '?' {
+			op = '&'
 		}
 	}
+	e.buf.WriteString(parser.Expr{Op: op, Vars: e.pending}.String())
+}
+
+// maxPooledBuffer is the capacity above which a buffer is not put back into
+// the pool, so that a single huge expansion does not pin memory forever.
+const maxPooledBuffer = 64 << 10
+
+// scratch is the memory that an expansion reuses from the previous ones:
+// the buffers holding the expression being written, and copies of the
+// options and of that expression, which the exprWriter points to, and
+// which would otherwise be moved to the heap on each call.
+type scratch struct {
+	buf  bytes.Buffer
+	esc  []byte // where values are escaped before being written to buf
+	opts Options
+	expr parser.Expr
+}
+
+var scratchPool = sync.Pool{
+	New: func() interface{} { return new(scratch) },
+}
+
+func getScratch() *scratch {
+	return scratchPool.Get().(*scratch)
+}
+
+func putScratch(s *scratch) {
+	if s.buf.Cap() <= maxPooledBuffer && cap(s.esc) <= maxPooledBuffer {
+		s.buf.Reset()
+		s.opts = Options{}
+		s.expr = parser.Expr{}
+		scratchPool.Put(s)
+	}
+}
+
+// newExprWriter returns the state shared by all the expressions of an
+// expansion of data. Its scratch comes from the pool and must be given back
+// with putScratch once the expansion is done.
+func newExprWriter(data interface{}, opts Options) exprWriter {
+	value, ok := data.(reflect.Value)
+	if !ok {
+		value = reflect.ValueOf(data)
+	}
+	dereference(&value)
+	scratch := getScratch()
+	scratch.opts = opts
+	e := exprWriter{buf: &scratch.buf, scratch: scratch, data: value, opts: &scratch.opts}
+	switch data := data.(type) {
+	case map[string]string:
+		e.strs = data
+	case map[string]interface{}:
+		e.ifaces = data
+	case Resolver:
+		e.resolver = data
+	case func(string) (interface{}, bool):
+		e.resolver = headResolver(data)
+	case func(string) (string, bool):
+		e.resolver = headResolver(func(name string) (interface{}, bool) {
+			s, ok := data(name)
+			return s, ok
+		})
+	}
+	return e
 }
 
 // Execute applies a parsed uritemplate to the specified data object,
 // and writes the output to w.
 //
-// data can be a reflect.Value.
+// data can be a reflect.Value. Pointers and interfaces are followed.
+//
+// Literals are written with parser.EscapeLiteral, so that the %XX sequences
+// of templates keep their meaning: "files/a%2Fb/{x}" expands to
+// "files/a%2Fb/y", not to a path of three segments, and "/a%3Fb" to
+// "/a%3Fb", not to a path followed by a query.
+//
+// Execute does not modify ast nor data, and can be called concurrently with
+// the same ones, as long as nothing else modifies them meanwhile. The
+// expressions of ast are copied before use, and may also be held as
+// *parser.Expr in Asts built by hand. The buffers reused across expansions
+// are taken from a sync.Pool, never kept on ast.
+//
+// data can also be a func(string) (interface{}, bool) or a
+// func(string) (string, bool), which is called with the head name of each
+// variable and reports whether it is defined, like a map lookup. The rest of
+// qualified names is then searched in the value returned.
 func Execute(ast *parser.Ast, w io.Writer, data interface{}) error {
-	value, ok := data.(reflect.Value)
-	if !ok {
-		value = reflect.ValueOf(data)
+	return ExecuteWith(ast, w, data, Options{})
+}
+
+// ExecuteFormat is like Execute, but the variables named in formats are
+// rendered by their formatting function instead of the default logic.
+// The formatted text is escaped as usual.
+func ExecuteFormat(
+	ast *parser.Ast,
+	w io.Writer,
+	data interface{},
+	formats map[string]func(interface{}) string,
+) error {
+	return ExecuteWith(ast, w, data, Options{Formats: formats})
+}
+
+// ExecuteEnv is like Execute, with data taken from env, a list of
+// "KEY=VALUE" entries in the format of os.Environ. Entries without an equals
+// sign are ignored, and when a key is repeated the last value wins, like in
+// os/exec.
+func ExecuteEnv(ast *parser.Ast, w io.Writer, env []string) error {
+	data := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			data[kv[:i]] = kv[i+1:]
+		}
 	}
+	return Execute(ast, w, data)
+}
+
+// ExecutePositional is like ExecuteWith, with data taken from values, bound
+// in order to the head names of the variables of ast in the order they first
+// appear, like the placeholders of SQL queries: "/{region}/{id}{?region}"
+// with "eu" and 42 gives "/eu/42?region=eu". Qualified names are searched in
+// the value of their head.
+//
+// More values than variables is an ArityError. With fewer values, the last
+// variables are undefined, or an ArityError in strict mode.
+func ExecutePositional(ast *parser.Ast, w io.Writer, values []interface{}, opts Options) error {
+	names := headNames(ast)
+	if len(values) > len(names) || opts.Strict && len(values) < len(names) {
+		return ArityError{Names: names, Values: len(values)}
+	}
+	data := make(map[string]interface{}, len(values))
+	for i, x := range values {
+		data[names[i]] = x
+	}
+	return ExecuteWith(ast, w, data, opts)
+}
+
+// headNames lists the head names of the variables of ast, once each, in the
+// order they first appear.
+func headNames(ast *parser.Ast) (names []string) {
+	seen := make(map[string]bool, len(ast.Vars))
 	for _, part := range ast.Parts {
+		expr, ok := parser.ExprPart(part)
+		if !ok {
+			continue
+		}
+		for _, v := range expr.Vars {
+			if !seen[v.ID[0]] {
+				seen[v.ID[0]] = true
+				names = append(names, v.ID[0])
+			}
+		}
+	}
+	return
+}
+
+// ExecuteLimited is like ExecuteWith with Options.MaxLen set to maxBytes: it
+// fails with a TooLongError instead of writing more than maxBytes bytes to w.
+// A maxBytes of zero or less means no limit.
+func ExecuteLimited(ast *parser.Ast, w io.Writer, data interface{}, maxBytes int) error {
+	return ExecuteWith(ast, w, data, Options{MaxLen: maxBytes})
+}
+
+// ExecuteWith is like Execute, with its behaviour changed by opts.
+//
+// Errors of w are returned wrapped in a WriteError. When another error is
+// returned, the expression that caused it was not written.
+func ExecuteWith(ast *parser.Ast, w io.Writer, data interface{}, opts Options) error {
+	return execute(ast, w, newExprWriter(data, opts))
+}
+
+// ExecuteOpts is like ExecuteWith, with the Options set by opts, in order.
+// Without any, it behaves exactly like Execute.
+func ExecuteOpts(ast *parser.Ast, w io.Writer, data interface{}, opts ...Option) error {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return ExecuteWith(ast, w, data, o)
+}
+
+// execute writes ast to w, expanding each expression with a copy of base.
+// It gives the scratch of base back to the pool.
+func execute(ast *parser.Ast, w io.Writer, base exprWriter) error {
+	defer putScratch(base.scratch)
+	if len(base.opts.Required) > 0 {
+		if err := base.checkRequired(); err != nil {
+			return err
+		}
+	}
+	for i, part := range ast.Parts {
+		base.part = i
 		switch part := part.(type) {
-		case parser.Expr:
-			ew := exprWriter{data: value, expr: &part}
+		case parser.Expr, *parser.Expr:
+			// expr is neither put back into part nor given to the
+			// WriteError, so that it stays on the stack
+			expr, ok := parser.ExprPart(part)
+			if !ok {
+				continue
+			}
+			base.buf.Reset()
+			base.scratch.expr = expr
+			ew := base
+			ew.expr = &base.scratch.expr
+			ew.operator = base.opts.operator(expr.Op)
 			ew.writeExpr()
-			if _, err := w.Write(ew.buf.Bytes()); err != nil {
-				return err
+			if ew.err != nil {
+				return ew.err
+			}
+			n, err := w.Write(ew.buf.Bytes())
+			base.written += n
+			if err != nil {
+				failed := expr
+				return WriteError{PartIndex: i, Written: base.written, Expr: &failed, Err: err}
 			}
 		case string:
-			if _, err := w.Write([]byte(part)); err != nil {
+			part = parser.EscapeLiteral(part)
+			if base.opts.EncodeLiterals {
+				part = escape.EscapeKeepEncoded(part, escape.Disallowed)
+			}
+			if err := base.writeLiteral(w, part); err != nil {
 				return err
 			}
 		case nil:
-			if _, err := w.Write([]byte{'/'}); err != nil {
+			if err := base.writeLiteral(w, base.opts.separator()); err != nil {
 				return err
 			}
 		}
 	}
 	return nil
 }
+
+// writeLiteral writes s to w, unless it would exceed Options.MaxLen.
+func (e *exprWriter) writeLiteral(w io.Writer, s string) error {
+	if e.opts.MaxLen > 0 && e.written+len(s) > e.opts.MaxLen {
+		return TooLongError{Limit: e.opts.MaxLen, Part: e.part}
+	}
+	n, err := io.WriteString(w, s)
+	e.written += n
+	if err != nil {
+		return WriteError{PartIndex: e.part, Written: e.written, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// ExpansionError is implemented by all the errors of the expansions. Those
+// about the data, such as ResolveError, give the variable they are about;
+// the others, such as WriteError and TooLongError, give nil. The errors of
+// the writer, of validators and of getters are wrapped, and can be
+// unwrapped.
+type ExpansionError interface {
+	error
+	VarPath() []string // the parts of the variable name, or nil
+}
+
+// ResolveError is returned when a variable that must be defined is not.
+type ResolveError struct {
+	Path []string
+}
+
+func (e ResolveError) Error() string {
+	return fmt.Sprintf("undefined variable %q", strings.Join(e.Path, "."))
+}
+
+func (e ResolveError) VarPath() []string { return e.Path }
+
+// FormatError is returned in strict mode when the MarshalText method of a
+// value fails. The value is otherwise rendered as if it had no such method.
+type FormatError struct {
+	Path  []string
+	Cause error // the error of MarshalText
+}
+
+func (e FormatError) Error() string {
+	return fmt.Sprintf("format of %q: %v", strings.Join(e.Path, "."), e.Cause)
+}
+
+func (e FormatError) VarPath() []string { return e.Path }
+
+func (e FormatError) Unwrap() error {
+	return e.Cause
+}
+
+// UnsupportedValueError is returned in strict mode when the value of a
+// variable cannot be expanded, such as a function or a channel. Those
+// values are otherwise undefined.
+type UnsupportedValueError struct {
+	Path []string
+	Kind reflect.Kind
+}
+
+func (e UnsupportedValueError) Error() string {
+	return fmt.Sprintf("unsupported %s value for %q", e.Kind, strings.Join(e.Path, "."))
+}
+
+func (e UnsupportedValueError) VarPath() []string { return e.Path }
+
+// LimitError is returned in strict mode when the value of a variable nests
+// lists and associative arrays deeper than Limit levels, which only cyclic
+// data does in practice. The deeper levels are otherwise left out.
+type LimitError struct {
+	Path  []string
+	Limit int
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("value of %q nested deeper than %d levels", strings.Join(e.Path, "."), e.Limit)
+}
+
+func (e LimitError) VarPath() []string { return e.Path }
+
+// RequiredError is returned when variables listed in Options.Required are
+// undefined.
+type RequiredError struct {
+	Names []string // the missing variables, in the order of Options.Required
+}
+
+func (e RequiredError) Error() string {
+	return fmt.Sprintf("missing required variables %q", e.Names)
+}
+
+func (e RequiredError) VarPath() []string { return nil }
+
+// ArityError is returned by ExecutePositional when the number of values
+// does not match the number of variables.
+type ArityError struct {
+	Names  []string // the head names of the variables, in order
+	Values int      // the number of values given
+}
+
+func (e ArityError) Error() string {
+	return fmt.Sprintf("%d values for the %d variables %q", e.Values, len(e.Names), e.Names)
+}
+
+func (e ArityError) VarPath() []string { return nil }
+
+// TooLongError is returned when the output of an expansion would exceed
+// Options.MaxLen.
+type TooLongError struct {
+	Limit int // the maximum length
+	Part  int // the index in Ast.Parts of the part that did not fit
+}
+
+func (e TooLongError) Error() string {
+	return fmt.Sprintf("expansion longer than %d bytes at part %d", e.Limit, e.Part)
+}
+
+func (e TooLongError) VarPath() []string { return nil }
+
+// ModOnScalarError is returned in strict mode when a modifier meant for
+// composite values is applied to a scalar.
+type ModOnScalarError struct {
+	Path []string
+	Mod  parser.Mod
+}
+
+func (e ModOnScalarError) Error() string {
+	return fmt.Sprintf("modifier %q on the scalar variable %q", e.Mod, strings.Join(e.Path, "."))
+}
+
+func (e ModOnScalarError) VarPath() []string { return e.Path }
+
+// ModOnCompositeError is returned in strict mode when a modifier meant for
+// scalar values is applied to a list or an associative array.
+type ModOnCompositeError struct {
+	Path []string
+	Mod  parser.Mod
+}
+
+func (e ModOnCompositeError) Error() string {
+	return fmt.Sprintf("modifier %q on the composite variable %q", e.Mod, strings.Join(e.Path, "."))
+}
+
+func (e ModOnCompositeError) VarPath() []string { return e.Path }
+
+// NestedCompositeError is returned in strict mode when a list that is not
+// exploded holds associative arrays, whose keys and values cannot be told
+// apart from the items once joined with commas.
+type NestedCompositeError struct {
+	Path []string
+}
+
+func (e NestedCompositeError) Error() string {
+	return fmt.Sprintf("associative array in the unexploded list %q", strings.Join(e.Path, "."))
+}
+
+func (e NestedCompositeError) VarPath() []string { return e.Path }
+
+// InvalidValueError is returned when Options.ValidateValue rejects a value.
+type InvalidValueError struct {
+	Path  []string
+	Value string
+	Err   error
+}
+
+func (e InvalidValueError) Error() string {
+	return fmt.Sprintf("invalid value %q for %q: %v", e.Value, strings.Join(e.Path, "."), e.Err)
+}
+
+func (e InvalidValueError) VarPath() []string { return e.Path }
+
+func (e InvalidValueError) Unwrap() error {
+	return e.Err
+}
+
+// MethodError is returned in strict mode when the getter method of a
+// variable, called because of Options.AllowMethods, returns an error.
+type MethodError struct {
+	Path []string // the variable, up to the part that the getter resolves
+	Err  error    // the error of the getter
+}
+
+func (e MethodError) Error() string {
+	return fmt.Sprintf("getter of %q: %v", strings.Join(e.Path, "."), e.Err)
+}
+
+func (e MethodError) VarPath() []string { return e.Path }
+
+func (e MethodError) Unwrap() error {
+	return e.Err
+}
+
+// ValuerError is returned in strict mode when the Value method of a variable
+// implementing driver.Valuer returns an error.
+type ValuerError struct {
+	Path []string
+	Err  error
+}
+
+func (e ValuerError) Error() string {
+	return fmt.Sprintf("value of %q: %v", strings.Join(e.Path, "."), e.Err)
+}
+
+func (e ValuerError) VarPath() []string { return e.Path }
+
+func (e ValuerError) Unwrap() error {
+	return e.Err
+}
+
+// IndexError is returned in strict mode when a part of a variable name
+// indexes a list out of its range.
+type IndexError struct {
+	Path []string // the variable, up to the index
+	Len  int      // the length of the list
+}
+
+func (e IndexError) Error() string {
+	return fmt.Sprintf("index out of range in %q with length %d", strings.Join(e.Path, "."), e.Len)
+}
+
+func (e IndexError) VarPath() []string { return e.Path }
+
+// KeyConversionError is returned in strict mode when a part of a variable
+// name does not convert to the key type of the map it looks up, such as
+// "{ports.http}" for a map with integer keys.
+type KeyConversionError struct {
+	Path []string     // the variable, up to the key
+	Type reflect.Type // the key type of the map
+	Err  error        // the error of the conversion
+}
+
+func (e KeyConversionError) Error() string {
+	return fmt.Sprintf("key of %q does not convert to %s: %v", strings.Join(e.Path, "."), e.Type, e.Err)
+}
+
+func (e KeyConversionError) VarPath() []string { return e.Path }
+
+func (e KeyConversionError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError is returned when the writer given to Execute fails.
+type WriteError struct {
+	PartIndex int          // the index in Ast.Parts of the part being written
+	Written   int          // the number of bytes written successfully
+	Expr      *parser.Expr // the expression being written, nil for literals
+	Err       error        // the error of the writer
+}
+
+func (e WriteError) Error() string {
+	return fmt.Sprintf("write error at part %d after %d bytes: %v", e.PartIndex, e.Written, e.Err)
+}
+
+func (e WriteError) VarPath() []string { return nil }
+
+func (e WriteError) Unwrap() error {
+	return e.Err
+}
@@ -1,12 +1,19 @@
 package execute
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/internal/ops"
 	"github.com/aksamyt/uritemplate/pkg/parser"
 )
 
@@ -18,20 +25,28 @@ type Example struct {
 
 type Fixture map[string]Example
 
-func TestSpecExamples(t *testing.T) {
-	f, err := os.Open("testdata/spec-examples-by-section.json")
+// loadFixture reads a fixture file of fixtures/, in the format of the
+// uritemplate-test suite.
+func loadFixture(t *testing.T, path string) Fixture {
+	j, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
-	}
-	j, err := io.ReadAll(f)
-	if err != nil {
-		panic(err)
+		t.Fatalf("fixture: %v", err)
 	}
 	var fixture Fixture
 	if err := json.Unmarshal(j, &fixture); err != nil {
 		panic(err)
 	}
-	for k, e := range fixture {
+	return fixture
+}
+
+func TestSpecExamples(t *testing.T) {
+	for k, e := range loadFixture(t, "fixtures/spec-examples-by-section.json") {
+		t.Run(k, func(t *testing.T) { runExample(t, e) })
+	}
+}
+
+func TestExtraExamples(t *testing.T) {
+	for k, e := range loadFixture(t, "fixtures/extra-examples.json") {
 		t.Run(k, func(t *testing.T) { runExample(t, e) })
 	}
 }
@@ -69,6 +84,12 @@ func runCases(
 		t.Errorf("execute error: %v", err)
 	}
 	got := out.String()
+	// reflect.Value data never takes the fast path for common maps
+	var slow strings.Builder
+	Execute(ast, &slow, reflect.ValueOf(data))
+	if slow.String() != got {
+		t.Errorf("fast path got:\n\t%q\nreflection got:\n\t%q\ninput:\n\t%q", got, slow.String(), input)
+	}
 	for _, expected := range cases {
 		if got == expected {
 			return
@@ -77,21 +98,909 @@ func runCases(
 	t.Errorf("got:\n\t%q\nexpected any of:\n\t%#v\ninput:\n\t%q", got, cases, input)
 }
 
+func TestMapOrder(t *testing.T) {
+	data := map[string]interface{}{
+		"m": map[string]string{
+			"echo":    "5",
+			"bravo":   "2",
+			"delta":   "4",
+			"alpha":   "1",
+			"charlie": "3",
+		},
+		"n": map[int]string{10: "b", 9: "a", 2: "c"},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{m}", "alpha,1,bravo,2,charlie,3,delta,4,echo,5"},
+		{"{m*}", "alpha=1,bravo=2,charlie=3,delta=4,echo=5"},
+		{"{?m}", "?m=alpha,1,bravo,2,charlie,3,delta,4,echo,5"},
+		{"{?m*}", "?alpha=1&bravo=2&charlie=3&delta=4&echo=5"},
+		{"{&m}", "&m=alpha,1,bravo,2,charlie,3,delta,4,echo,5"},
+		{"{&m*}", "&alpha=1&bravo=2&charlie=3&delta=4&echo=5"},
+		{"{;m}", ";m=alpha,1,bravo,2,charlie,3,delta,4,echo,5"},
+		{"{;m*}", ";alpha=1;bravo=2;charlie=3;delta=4;echo=5"},
+		{"{n}", "10,b,2,c,9,a"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			for i := 0; i < 10; i++ {
+				var out strings.Builder
+				Execute(ast, &out, data)
+				if got := out.String(); got != tt.expected {
+					t.Fatalf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestAllUndefined(t *testing.T) {
+	data := map[string]interface{}{"defined": "yes"}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"a{?x,y}b", "ab"},
+		{"a{&x,y}b", "ab"},
+		{"a{;x,y}b", "ab"},
+		{"a{#x,y}b", "ab"},
+		{"a{.x,y}b", "ab"},
+		{"a{/x,y}b", "ab"},
+		{"a{x,y}b", "ab"},
+		{"a{+x,y}b", "ab"},
+		{"a{?x,defined}b", "a?defined=yesb"},
+		{"a{&x,defined}b", "a&defined=yesb"},
+		{"a{;x,defined}b", "a;defined=yesb"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEmptyComposites(t *testing.T) {
+	data := map[string]interface{}{
+		"keys": map[string]string{},
+		"list": []string{},
+		"var":  "value",
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{?keys*}", ""},
+		{"{&list}", ""},
+		{"{;keys}", ""},
+		{"{/list*}", ""},
+		{"{.keys*}", ""},
+		{"{#list}", ""},
+		{"{?keys*,var}", "?var=value"},
+		{"{?var,keys*}", "?var=value"},
+		{"{&list,var}", "&var=value"},
+		{"{;var,keys}", ";var=value"},
+		{"{/list*,var,keys}", "/value"},
+		{"{.keys,var}", ".value"},
+		{"{#list,var}", "#value"},
+		{"{var,list}", "value"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestCommaValues checks that the commas of values are escaped whenever
+// reserved characters are, so that they cannot be mistaken for the commas
+// separating list items.
+func TestCommaValues(t *testing.T) {
+	data := map[string]interface{}{
+		"var":  "a,b",
+		"list": []string{"a,b", "c"},
+		"keys": map[string]string{"k,1": "v,2"},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{var}", "a%2Cb"},
+		{"{var:2}", "a%2C"},
+		{"{var:3}", "a%2Cb"},
+		{"{+var}", "a,b"},
+		{"{+var:2}", "a,"},
+		{"{#var}", "#a,b"},
+		{"{.var}", ".a%2Cb"},
+		{"{/var}", "/a%2Cb"},
+		{"{;var}", ";var=a%2Cb"},
+		{"{?var}", "?var=a%2Cb"},
+		{"{?var:2}", "?var=a%2C"},
+		{"{&var}", "&var=a%2Cb"},
+		{"{list}", "a%2Cb,c"},
+		{"{+list}", "a,b,c"},
+		{"{?list}", "?list=a%2Cb,c"},
+		{"{?list*}", "?list=a%2Cb&list=c"},
+		{"{keys}", "k%2C1,v%2C2"},
+		{"{?keys*}", "?k%2C1=v%2C2"},
+		{"{+keys*}", "k,1=v,2"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// unregisterOperator undoes RegisterOperator, so that the operators
+// registered by a test do not leak into the others.
+func unregisterOperator(op byte) {
+	extensionOps.Delete(op)
+	ops.Unregister(op)
+}
+
+func TestRegisterOperator(t *testing.T) {
+	RegisterOperator('~', '~', escape.Disallowed|escape.Reserved, true)
+	RegisterOperator('!', '/', escape.Disallowed, false)
+	t.Cleanup(func() {
+		unregisterOperator('~')
+		unregisterOperator('!')
+	})
+	data := map[string]interface{}{
+		"a":    "x/y",
+		"b":    "z",
+		"list": []string{"1", "2"},
+		"keys": map[string]string{"k": "v"},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{~a,b}", "~x%2Fy~z"},
+		{"/r{~list}{~list*}", "/r~1,2~1~2"},
+		{"{~keys*}", "~k=v"},
+		{"{~missing}", ""},
+		{"{!a,b}", "x/y/z"},
+		{"{!keys}", "k,v"},
+		{"{+a}{~b}", "x/y~z"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, err := parser.Parse(tt.template)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLiteralEscapes(t *testing.T) {
+	data := map[string]string{"x": "y"}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"files/a%2Fb/{x}", "files/a%2Fb/y"},
+		{"/100%25{/x}", "/100%25/y"},
+		{"/a%20b/%7Bx%7D", "/a%20b/%7Bx%7D"},
+		{"/caf%c3%a9/%3F", "/caf%C3%A9/%3F"},
+		{"/a%23b%3Fc{?x}", "/a%23b%3Fc?x=y"},
+		{"/%7euser%2D1", "/~user-1"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			if got, _ := ExecuteString(ast, data); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			tmpl, _ := Compile(ast)
+			var out strings.Builder
+			tmpl.Execute(&out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("compiled got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOperatorMask(t *testing.T) {
+	RegisterOperator('$', '$', escape.Disallowed, true)
+	t.Cleanup(func() { unregisterOperator('$') })
+	const (
+		simple   = escape.Disallowed | escape.Reserved
+		reserved = escape.Disallowed
+	)
+	for _, tt := range []struct {
+		op     byte
+		varsep byte
+		mask   byte
+	}{
+		{0, ',', simple},
+		{'+', ',', reserved},
+		{'#', ',', reserved},
+		{'.', '.', simple},
+		{'/', '/', simple},
+		{';', ';', simple},
+		{'?', '&', simple},
+		{'&', '&', simple},
+		{'=', ',', simple},
+		{'$', '$', reserved},
+	} {
+		t.Run(string(rune(tt.op)), func(t *testing.T) {
+			varsep, mask := OperatorMask(tt.op)
+			if varsep != tt.varsep || mask != tt.mask {
+				t.Errorf("got:\n\t%q, %#x\nexpected:\n\t%q, %#x", varsep, mask, tt.varsep, tt.mask)
+			}
+		})
+	}
+}
+
+func TestNilValues(t *testing.T) {
+	var (
+		nilString *string
+		nilMap    map[string]string
+		nilSlice  []string
+	)
+	expected := map[string]string{
+		"":  "v",
+		"+": "v",
+		"#": "#v",
+		".": ".v",
+		"/": "/v",
+		";": ";y=v",
+		"?": "?y=v",
+		"&": "&y=v",
+	}
+	expectedEmpty := map[string]string{
+		"":  ",v",
+		"+": ",v",
+		"#": "#,v",
+		".": "..v",
+		"/": "//v",
+		";": ";x;y=v",
+		"?": "?x=&y=v",
+		"&": "&x=&y=v",
+	}
+	for _, x := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"nil interface", nil},
+		{"nil pointer", nilString},
+		{"nil map", nilMap},
+		{"nil slice", nilSlice},
+		{"empty map", map[string]string{}},
+		{"empty slice", []string{}},
+		{"map of nils", map[string]interface{}{"a": nil, "b": nilString}},
+		{"slice of nils", []interface{}{nil, nilString}},
+		{"empty string", ""},
+	} {
+		for op := range expected {
+			for _, explode := range []string{"", "*"} {
+				template := fmt.Sprintf("{%sx%s,y}", op, explode)
+				t.Run(x.name+" "+template, func(t *testing.T) {
+					ast, _ := parser.Parse(template)
+					var out strings.Builder
+					Execute(ast, &out, map[string]interface{}{"x": x.value, "y": "v"})
+					want := expected[op]
+					if x.name == "empty string" {
+						want = expectedEmpty[op]
+					}
+					if got := out.String(); got != want {
+						t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+type Filter struct {
+	Query *string `uri:"q"`
+	Sort  *string `uri:"sort"`
+	Tag   string  `uri:"tag"`
+	Page  *int    `uri:"page"`
+}
+
+func TestPointerScalars(t *testing.T) {
+	empty, zero := "", 0
+	filter := Filter{Query: nil, Sort: &empty, Tag: "", Page: &zero}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{;f.q,f.sort,f.tag}", ";sort;tag"},
+		{"{?f.q,f.sort,f.tag}", "?sort=&tag="},
+		{"{&f.q,f.sort}", "&sort="},
+		{"{f.q,f.sort,f.tag}", ","},
+		{"X{f.q}X", "XX"},
+		{"X{f.sort}X", "XX"},
+		{"{?f.page}", "?page=0"},
+		{"{;f*}", ";sort;tag;page=0"},
+		{"{?f*}", "?sort=&tag=&page=0"},
+		{"{f*}", "sort=,tag=,page=0"},
+		{"{;list*}", ";list;list=a"},
+		{"{?list*}", "?list=&list=a"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			report, _ := ExecuteReport(ast, &out, map[string]interface{}{
+				"f":    filter,
+				"list": []*string{nil, &empty, nil, &[]string{"a"}[0]},
+			})
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			for _, path := range report.Undefined {
+				if path != "f.q" {
+					t.Errorf("got:\n\t%q undefined\nexpected only:\n\t%q", path, "f.q")
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteEnv(t *testing.T) {
+	env := []string{
+		"HOME=/home/gontrand",
+		"LANG=fr_FR.UTF-8",
+		"EMPTY=",
+		"BROKEN",
+		"EQUALS=a=b",
+		"LANG=C",
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{+HOME}/.config", "/home/gontrand/.config"},
+		{"{HOME}", "%2Fhome%2Fgontrand"},
+		{"{?LANG,EMPTY,BROKEN,EQUALS}", "?LANG=C&EMPTY=&EQUALS=a%3Db"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			if err := ExecuteEnv(ast, &out, env); err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecutePositional(t *testing.T) {
+	user := map[string]interface{}{"id": 7, "name": "gontrand"}
+	for _, tt := range []struct {
+		template string
+		values   []interface{}
+		strict   bool
+		expected string
+		err      error
+	}{
+		{"/{region}/{id}{?region}", []interface{}{"eu", 42}, false, "/eu/42?region=eu", nil},
+		{"/users/{user.id}{/user.name,page}", []interface{}{user, 2}, false, "/users/7/gontrand/2", nil},
+		{"/{region}/{id}", []interface{}{"eu"}, false, "/eu/", nil},
+		{"/{region}/{id}", []interface{}{"eu"}, true, "", ArityError{Names: []string{"region", "id"}, Values: 1}},
+		{"/{region}{?region}", []interface{}{"eu", 42}, false, "", ArityError{Names: []string{"region"}, Values: 2}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecutePositional(ast, &out, tt.values, Options{Strict: tt.strict})
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestInvalidWriter(t *testing.T) {
 	pin, pout := io.Pipe()
 	pin.Close()
 	defer pout.Close()
-	for _, template := range []string{
-		"/",
-		"test",
-		"{var}",
+	for _, tt := range []struct {
+		template  string
+		partIndex int
+		expr      bool
+	}{
+		{"/", 0, false},
+		{"test", 0, false},
+		{"{var}", 0, true},
 	} {
-		t.Run(template, func(t *testing.T) {
-			ast, _ := parser.Parse(template)
-			err := Execute(ast, pout, nil)
-			if err == nil {
-				t.Error("expected an error")
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			err := Execute(ast, pout, map[string]string{"var": "value"})
+			var we WriteError
+			if !errors.As(err, &we) {
+				t.Fatalf("expected a WriteError, got:\n\t%#v", err)
+			}
+			if we.PartIndex != tt.partIndex || we.Written != 0 || (we.Expr != nil) != tt.expr {
+				t.Errorf("got:\n\t%#v", we)
+			}
+			if !errors.Is(err, io.ErrClosedPipe) {
+				t.Errorf("got:\n\t%v\nexpected to wrap:\n\t%v", err, io.ErrClosedPipe)
 			}
 		})
 	}
 }
+
+// failAfter fails once n bytes were written to it.
+type failAfter struct {
+	n int
+}
+
+func (f *failAfter) Write(p []byte) (int, error) {
+	if len(p) > f.n {
+		n := f.n
+		f.n = 0
+		return n, io.ErrShortWrite
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteErrorContext(t *testing.T) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page}")
+	data := map[string]string{"id": "42", "page": "2"}
+	for _, tt := range []struct {
+		n         int
+		partIndex int
+		expr      string
+	}{
+		{3, 1, ""},
+		{8, 3, "{id}"},
+		{10, 5, ""},
+		{19, 6, "{?page}"},
+	} {
+		t.Run(fmt.Sprint(tt.n), func(t *testing.T) {
+			err := Execute(ast, &failAfter{tt.n}, data)
+			var we WriteError
+			if !errors.As(err, &we) {
+				t.Fatalf("expected a WriteError, got:\n\t%#v", err)
+			}
+			expr := ""
+			if we.Expr != nil {
+				expr = we.Expr.String()
+			}
+			if we.PartIndex != tt.partIndex || we.Written != tt.n || expr != tt.expr {
+				t.Errorf("got:\n\t%d, %d, %q\nexpected:\n\t%d, %d, %q",
+					we.PartIndex, we.Written, expr, tt.partIndex, tt.n, tt.expr)
+			}
+		})
+	}
+}
+
+// TestConcurrentExecute is meant to be run with -race: expansions share
+// pooled buffers, and none of them should see another's output.
+func TestConcurrentExecute(t *testing.T) {
+	ast, _ := parser.Parse("{#x}/{y}{.x,y}{?x,y,empty}{&undef}")
+	const expected = "#1024/768.1024.768?x=1024&y=768&empty="
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			data := map[string]interface{}{"x": "1024", "y": "768", "empty": ""}
+			if g%2 == 1 {
+				// Grow the buffer past the pooling limit now and then.
+				data["y"] = strings.Repeat("7", maxPooledBuffer+1)
+			}
+			for i := 0; i < 100; i++ {
+				var out strings.Builder
+				if err := Execute(ast, &out, data); err != nil {
+					t.Errorf("execute error: %v", err)
+					return
+				}
+				if g%2 == 0 && out.String() != expected {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", out.String(), expected)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// TestConcurrentSharedAst is meant to be run with -race: one Ast is expanded
+// from 32 goroutines with different data, whether its expressions are held
+// as values, like the parser gives, or as pointers, some of them nil.
+func TestConcurrentSharedAst(t *testing.T) {
+	parsed, _ := parser.Parse("/users/{id}{/tags*}{?page,missing}{#frag}")
+	pointers := &parser.Ast{Vars: parsed.Vars}
+	for _, part := range parsed.Parts {
+		if expr, ok := part.(parser.Expr); ok {
+			// a nil expression expands to nothing
+			pointers.Parts = append(pointers.Parts, (*parser.Expr)(nil))
+			part = &expr
+		}
+		pointers.Parts = append(pointers.Parts, part)
+	}
+	for name, ast := range map[string]*parser.Ast{"Expr": parsed, "*Expr": pointers} {
+		t.Run(name, func(t *testing.T) {
+			tpl, err := Compile(ast)
+			if err != nil {
+				t.Fatalf("compile error: %v", err)
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < 32; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					data := map[string]interface{}{
+						"id":   g,
+						"tags": []string{fmt.Sprint("t", g), "all"},
+						"page": g * 10,
+					}
+					opts := Options{OnUndefined: func(path []string, expr *parser.Expr) (interface{}, bool) {
+						return path[0] + expr.String(), expr.Op == '#'
+					}}
+					expected := fmt.Sprintf("/users/%d/t%d/all?page=%d#frag%%7B#frag%%7D", g, g, g*10)
+					for i := 0; i < 50; i++ {
+						var out strings.Builder
+						if err := ExecuteWith(ast, &out, data, opts); err != nil {
+							t.Errorf("execute error: %v", err)
+							return
+						}
+						if got := out.String(); got != expected {
+							t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+							return
+						}
+						if got, _ := tpl.String(data); got != expected[:strings.IndexByte(expected, '#')] {
+							t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkExecuteExprs measures the per-expression cost of a template made
+// mostly of expressions.
+func BenchmarkExecuteExprs(b *testing.B) {
+	ast, _ := parser.Parse("{a}{+a}{#a}{.a}{/a}{;a}{?a}{&a}")
+	data := map[string]string{"a": "value"}
+	b.ReportAllocs()
+	var out bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		Execute(ast, &out, data)
+	}
+}
+
+// maxExecuteAllocs is the allocation budget of expanding a typical route
+// with a map of strings.
+const maxExecuteAllocs = 3
+
+func BenchmarkExecuteRoute(b *testing.B) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page,per_page}")
+	data := map[string]string{"id": "270319070", "page": "2", "per_page": "50"}
+	b.ReportAllocs()
+	var out bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		Execute(ast, &out, data)
+	}
+}
+
+func TestExecuteAllocs(t *testing.T) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page,per_page}")
+	data := map[string]string{"id": "270 319 070", "page": "2", "per_page": "50"}
+	var out bytes.Buffer
+	Execute(ast, &out, data)
+	out.Reset()
+	allocs := testing.AllocsPerRun(100, func() {
+		out.Reset()
+		Execute(ast, &out, data)
+	})
+	if allocs > maxExecuteAllocs {
+		t.Errorf("got:\n\t%v allocs per run\nexpected at most:\n\t%v", allocs, maxExecuteAllocs)
+	}
+	if got, expected := out.String(), "/users/270%20319%20070/posts?page=2&per_page=50"; got != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+}
+
+func BenchmarkExpandToString(b *testing.B) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page,per_page}")
+	data := map[string]string{"id": "270319070", "page": "2", "per_page": "50"}
+	b.Run("bytes.Buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out bytes.Buffer
+			Execute(ast, &out, data)
+			_ = out.String()
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ExpandToString(ast, data)
+		}
+	})
+}
+
+func TestExpandToString(t *testing.T) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page,per_page}")
+	data := map[string]string{"id": "270 319 070", "page": "2", "per_page": "50"}
+	expected := "/users/270%20319%20070/posts?page=2&per_page=50"
+	for i := 0; i < 3; i++ {
+		if got, err := ExpandToString(ast, data); got != expected || err != nil {
+			t.Errorf("got:\n\t%q, %v\nexpected:\n\t%q", got, err, expected)
+		}
+	}
+}
+
+func BenchmarkExecuteMap(b *testing.B) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page,per_page,sort}")
+	data := map[string]string{
+		"id":       "270319070",
+		"page":     "2",
+		"per_page": "50",
+		"sort":     "date desc",
+	}
+	for _, bb := range []struct {
+		name string
+		data interface{}
+	}{
+		{"map[string]string", data},
+		{"map[string]interface{}", map[string]interface{}{
+			"id":       data["id"],
+			"page":     data["page"],
+			"per_page": data["per_page"],
+			"sort":     data["sort"],
+		}},
+		{"reflection", reflect.ValueOf(data)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var out bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				out.Reset()
+				Execute(ast, &out, bb.data)
+			}
+		})
+	}
+}
+
+func TestExecuteReport(t *testing.T) {
+	data := map[string]interface{}{
+		"id":     "42",
+		"empty":  "",
+		"null":   nil,
+		"list":   []string{},
+		"user":   map[string]interface{}{"name": "", "id": "7"},
+		"cursor": nil,
+	}
+	ast, _ := parser.Parse("/users/{id,empty}{/user.id,user.name}{;empty,absent}{?null,list,cursor,user.email}")
+	var out strings.Builder
+	report, err := ExecuteReport(ast, &out, data)
+	if err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if got, expected := out.String(), "/users/42,/7/;empty"; got != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+	expected := Report{
+		Undefined: []string{"absent", "null", "list", "cursor", "user.email"},
+		Empty:     []string{"empty", "user.name", "empty"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", report, expected)
+	}
+}
+
+func TestExecuteLimited(t *testing.T) {
+	list := make([]string, 10000)
+	for i := range list {
+		list[i] = fmt.Sprint(i)
+	}
+	data := map[string]interface{}{"list": list, "id": "42"}
+	for _, tt := range []struct {
+		template string
+		limit    int
+		expected string
+		fail     bool
+	}{
+		{"/items/{id}", 100, "/items/42", false},
+		{"/items/{id}", 9, "/items/42", false},
+		{"/items/{id}", 8, "/items/", true},
+		{"/items/{id}{?list*}", 1000, "/items/42", true},
+		{"{list*}/items", 100, "", true},
+	} {
+		t.Run(fmt.Sprint(tt.template, tt.limit), func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteLimited(ast, &out, data, tt.limit)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			if !tt.fail {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var tle TooLongError
+			if !errors.As(err, &tle) || tle.Limit != tt.limit {
+				t.Errorf("expected a TooLongError, got:\n\t%#v", err)
+			}
+		})
+	}
+}
+
+var errBadText = errors.New("bad text")
+
+type badText int
+
+func (badText) MarshalText() ([]byte, error) { return nil, errBadText }
+
+func TestErrorTaxonomy(t *testing.T) {
+	cyclic := []interface{}{nil}
+	cyclic[0] = cyclic
+	data := map[string]interface{}{
+		"id":     42,
+		"text":   badText(0),
+		"texts":  []interface{}{"a", badText(0)},
+		"fn":     func() {},
+		"cyclic": cyclic,
+		"bytes":  []byte("abc"),
+		"list":   []string{"a", "b"},
+		"nested": []map[string]string{{"k": "v"}},
+		"fail":   failingValuer{},
+		"ports":  map[int]string{80: "http"},
+	}
+	strict := Options{Strict: true}
+	for _, tt := range []struct {
+		name     string
+		template string
+		opts     Options
+		w        io.Writer
+		as       func(error) bool
+		path     []string
+	}{
+		{"ResolveError", "/{missing}", Options{RequirePathVars: true},
+			nil, func(err error) bool { var e ResolveError; return errors.As(err, &e) }, []string{"missing"}},
+		{"FormatError", "{text}", strict,
+			nil, func(err error) bool { var e FormatError; return errors.As(err, &e) && errors.Is(err, errBadText) },
+			[]string{"text"}},
+		{"FormatError in a list", "{/texts*}", strict,
+			nil, func(err error) bool { var e FormatError; return errors.As(err, &e) }, []string{"texts"}},
+		{"UnsupportedValueError", "{fn}", strict,
+			nil, func(err error) bool {
+				var e UnsupportedValueError
+				return errors.As(err, &e) && e.Kind == reflect.Func
+			}, []string{"fn"}},
+		{"LimitError", "{cyclic}", strict,
+			nil, func(err error) bool {
+				var e LimitError
+				return errors.As(err, &e) && e.Limit == maxNesting
+			}, []string{"cyclic"}},
+		{"ModOnScalarError", "{bytes*}", strict,
+			nil, func(err error) bool { var e ModOnScalarError; return errors.As(err, &e) }, []string{"bytes"}},
+		{"ModOnCompositeError", "{list:1}", strict,
+			nil, func(err error) bool { var e ModOnCompositeError; return errors.As(err, &e) }, []string{"list"}},
+		{"NestedCompositeError", "{nested}", strict,
+			nil, func(err error) bool { var e NestedCompositeError; return errors.As(err, &e) }, []string{"nested"}},
+		{"IndexError", "{list.2}", strict,
+			nil, func(err error) bool { var e IndexError; return errors.As(err, &e) }, []string{"list", "2"}},
+		{"KeyConversionError", "{ports.http}", strict,
+			nil, func(err error) bool { var e KeyConversionError; return errors.As(err, &e) }, []string{"ports", "http"}},
+		{"ValuerError", "{fail}", strict,
+			nil, func(err error) bool { var e ValuerError; return errors.As(err, &e) }, []string{"fail"}},
+		{"InvalidValueError", "{id}", Options{ValidateValue: func(*parser.Var, byte, string) error { return errBadText }},
+			nil, func(err error) bool {
+				var e InvalidValueError
+				return errors.As(err, &e) && errors.Is(err, errBadText)
+			}, []string{"id"}},
+		{"RequiredError", "{id}", Options{Required: []string{"missing"}},
+			nil, func(err error) bool { var e RequiredError; return errors.As(err, &e) }, nil},
+		{"TooLongError", "/items/{id}", Options{MaxLen: 8},
+			nil, func(err error) bool { var e TooLongError; return errors.As(err, &e) }, nil},
+		{"WriteError", "/items/{id}", Options{},
+			&failAfter{3}, func(err error) bool {
+				var e WriteError
+				return errors.As(err, &e) && errors.Is(err, io.ErrShortWrite)
+			}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := parser.Parse(tt.template)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if tt.w == nil {
+				tt.w = io.Discard
+			}
+			err = ExecuteWith(ast, tt.w, data, tt.opts)
+			if !tt.as(err) {
+				t.Fatalf("got:\n\t%#v\nexpected a %s", err, tt.name)
+			}
+			var ee ExpansionError
+			if !errors.As(err, &ee) {
+				t.Fatalf("got:\n\t%#v\nexpected an ExpansionError", err)
+			}
+			if got := ee.VarPath(); !reflect.DeepEqual(got, tt.path) {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.path)
+			}
+		})
+	}
+	ast, _ := parser.Parse("{text}{fn}{cyclic}")
+	if _, err := ExecuteString(ast, data); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDefinedEmpty(t *testing.T) {
+	type Page struct {
+		Title  string
+		Anchor *string
+		Path   Reserved
+		Undef  *string
+	}
+	empty := ""
+	page := Page{Anchor: &empty}
+	for _, tt := range []struct {
+		template string
+		opts     Options
+		expected string
+	}{
+		{"{#Title}{#Anchor}{#Path}{#Undef}", Options{}, "###"},
+		{"{.Title}{.Anchor}{.Path}{.Undef}", Options{}, "..."},
+		{"{;Title}{;Anchor}{;Path}{;Undef}", Options{}, ";Title;Anchor;Path"},
+		{"{#Undef,Title}{;Undef,Anchor}", Options{}, "#;Anchor"},
+		{"{#Title}{.Anchor}{;Title,Anchor}", Options{OmitZero: true}, ".;Anchor"},
+		{"{#Undef}{.Undef}", Options{Formats: map[string]func(interface{}) string{
+			"Undef": func(interface{}) string { return "" },
+		}}, ""},
+		{"{#Title}{.Title}{;Title}", Options{Formats: map[string]func(interface{}) string{
+			"Title": func(interface{}) string { return "" },
+		}}, "#.;Title"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			if err := ExecuteWith(ast, &out, page, tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLongPrefix(t *testing.T) {
+	ast, err := parser.Config{MaxPrefix: 50000}.Parse("{var:20000}{?var:16384}")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	value := strings.Repeat("a", 30000)
+	got, _ := ExecuteString(ast, map[string]string{"var": value})
+	if expected := value[:20000] + "?var=" + value[:16384]; got != expected {
+		t.Errorf("got %d bytes, expected %d", len(got), len(expected))
+	}
+}
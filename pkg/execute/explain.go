@@ -0,0 +1,112 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// StepKind tells what a Step of an expansion wrote.
+type StepKind int
+
+// The kinds of Step.
+const (
+	// StepLiteral is a literal part of the template.
+	StepLiteral StepKind = iota
+	// StepSeparator is a path separator of the template.
+	StepSeparator
+	// StepSign is the sign of an operator, such as the '?' of "{?a,b}".
+	StepSign
+	// StepVariable is a defined variable, with its separator and key.
+	StepVariable
+	// StepUndefined is an undefined variable, which wrote nothing.
+	StepUndefined
+)
+
+var stepKinds = [...]string{"literal", "separator", "sign", "variable", "undefined"}
+
+func (k StepKind) String() string {
+	if k < 0 || int(k) >= len(stepKinds) {
+		return fmt.Sprintf("StepKind(%d)", int(k))
+	}
+	return stepKinds[k]
+}
+
+// Step is what one part of a template, or one variable of an expression,
+// wrote during an expansion.
+type Step struct {
+	Part   int      // the index of the part in the template
+	Kind   StepKind // what was written
+	Name   string   // the dotted name of the variable, if any
+	Output string   // the bytes written
+}
+
+// Explain expands the template like Execute, and returns the steps of the
+// expansion instead of its output: the literals and separators, the signs of
+// the operators, and the variables, with what each of them wrote. The sign
+// of an expression without any defined variable is not written, and has no
+// step. Explain is meant for debugging: it stops at the first error, and
+// returns the steps before it.
+func (t *Template) Explain(data interface{}) []Step {
+	steps := []Step{}
+	base := newExprWriter(data, Options{})
+	base.steps = &steps
+	t.execute(io.Discard, base)
+	return steps
+}
+
+// explain records the step of a sign or of a variable, which wrote the
+// bytes of the buffer from n on. i is the number of defined variables
+// before it.
+func (e *exprWriter) explain(kind StepKind, v *parser.Var, n int, i int) {
+	if e.steps == nil {
+		return
+	}
+	s := Step{Part: e.part, Kind: kind, Output: string(e.buf.Bytes()[n:])}
+	if v != nil {
+		s.Name = strings.Join(v.ID, ".")
+		if e.i == i {
+			s.Kind = StepUndefined
+		}
+	}
+	*e.steps = append(*e.steps, s)
+}
+
+// unexplainSign forgets the sign of the expression being written, when it
+// was not written after all.
+func (e *exprWriter) unexplainSign() {
+	if e.steps == nil {
+		return
+	}
+	steps := *e.steps
+	for i := len(steps) - 1; i >= 0 && steps[i].Part == e.part; i-- {
+		if steps[i].Kind == StepSign {
+			*e.steps = append(steps[:i], steps[i+1:]...)
+			return
+		}
+	}
+}
+
+// explainLiteral records the step of a literal or separator of a compiled
+// template.
+func (e *exprWriter) explainLiteral(s *step) {
+	if e.steps == nil {
+		return
+	}
+	kind := StepLiteral
+	if s.sep {
+		kind = StepSeparator
+	}
+	*e.steps = append(*e.steps, Step{Part: e.part, Kind: kind, Output: s.literal})
+}
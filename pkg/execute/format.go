@@ -0,0 +1,169 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// URIValue is implemented by values that control how they appear in a URI.
+// URIString gives the text of the value, which is then escaped like any
+// other. It takes precedence over encoding.TextMarshaler and fmt.Stringer,
+// so that types can render differently in URIs than for display.
+type URIValue interface {
+	URIString() string
+}
+
+// textOf returns the text of value if it implements URIValue,
+// encoding.TextMarshaler or fmt.Stringer, in that order of preference.
+//
+// Big numbers are special-cased: big.Float would otherwise switch to
+// scientific notation for large exponents.
+func textOf(value reflect.Value) (string, bool) {
+	if !value.CanInterface() {
+		return "", false
+	}
+	switch v := value.Interface().(type) {
+	case URIValue:
+		return v.URIString(), true
+	case *big.Float:
+		return v.Text('f', -1), true
+	case big.Float:
+		return v.Text('f', -1), true
+	case big.Int:
+		return v.String(), true
+	case encoding.TextMarshaler:
+		if text, err := v.MarshalText(); err == nil {
+			return string(text), true
+		}
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// text is like textOf, but when value is addressable the method set of its
+// pointer is checked too, so that methods with pointer receivers are found on
+// struct fields and slice elements.
+func text(value reflect.Value) (string, bool) {
+	if value.CanAddr() {
+		if s, ok := textOf(value.Addr()); ok {
+			return s, true
+		}
+	}
+	return textOf(value)
+}
+
+// marshalError returns the error of the MarshalText method that text would
+// have used for value, had it not failed, or nil.
+func marshalError(value reflect.Value) error {
+	if _, ok := text(value); ok {
+		return nil
+	}
+	candidates := []reflect.Value{value}
+	if value.CanAddr() {
+		candidates = []reflect.Value{value.Addr(), value}
+	}
+	for _, v := range candidates {
+		if !v.CanInterface() {
+			continue
+		}
+		if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+			_, err := m.MarshalText()
+			return err
+		}
+	}
+	return nil
+}
+
+var stringType = reflect.TypeOf("")
+
+// Reserved is a string expanded like with the '+' operator whatever the
+// operator of its expression: only the characters that are not allowed
+// anywhere in a URI are escaped, and its pct-encoded triplets are kept, so
+// that a path such as "a/b%20c" keeps its slashes under the default
+// operator.
+type Reserved string
+
+var reservedType = reflect.TypeOf(Reserved(""))
+
+// stringify renders a scalar value before it gets escaped.
+//
+// Numbers are formatted with strconv rather than fmt, so that floats never
+// switch to scientific notation: 1e7 is "10000000". A float is written with
+// the fewest digits that read back as the same value at its own precision,
+// so float32(0.1) is "0.1".
+func stringify(value reflect.Value) string {
+	if value.Type() == stringType {
+		return value.String()
+	}
+	if s, ok := text(value); ok {
+		return s
+	}
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(value.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'f', -1, value.Type().Bits())
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool())
+	case reflect.Slice, reflect.Array:
+		if isBytes(value) {
+			b := make([]byte, value.Len())
+			reflect.Copy(reflect.ValueOf(b), value)
+			return string(b)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// isBytes reports whether value is a slice or an array of bytes, which is
+// rendered as a scalar holding the raw bytes.
+func isBytes(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return value.Type().Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
+// sortedMapKeys returns the keys of a map value sorted by their rendered
+// string, so that expanding a map always gives the same output, along with
+// these strings. The keys of maps with interface keys, such as decoded YAML,
+// are rendered after the value they hold.
+func sortedMapKeys(value reflect.Value) ([]reflect.Value, []string) {
+	keys := value.MapKeys()
+	rendered := make([]string, len(keys))
+	for i, key := range keys {
+		dereference(&key)
+		rendered[i] = stringify(key)
+	}
+	sort.Sort(byRendered{keys, rendered})
+	return keys, rendered
+}
+
+type byRendered struct {
+	keys     []reflect.Value
+	rendered []string
+}
+
+func (b byRendered) Len() int           { return len(b.keys) }
+func (b byRendered) Less(i, j int) bool { return b.rendered[i] < b.rendered[j] }
+func (b byRendered) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.rendered[i], b.rendered[j] = b.rendered[j], b.rendered[i]
+}
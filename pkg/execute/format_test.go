@@ -0,0 +1,300 @@
+package execute
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+func bigInt(s string) *big.Int {
+	i, _ := new(big.Int).SetString(s, 10)
+	return i
+}
+
+func bigFloat(s string) *big.Float {
+	f, _, _ := big.ParseFloat(s, 10, 200, big.ToNearestEven)
+	return f
+}
+
+func TestBigNumbers(t *testing.T) {
+	ast, _ := parser.Parse("{id}{?id}")
+	for _, tt := range []struct {
+		name     string
+		id       interface{}
+		expected string
+	}{
+		{"big.Int", bigInt("123456789012345678901234567890"),
+			"123456789012345678901234567890?id=123456789012345678901234567890"},
+		{"negative big.Int", bigInt("-42"), "-42?id=-42"},
+		{"big.Int value", *bigInt("42"), "42?id=42"},
+		{"big.Float", bigFloat("1e30"),
+			"1000000000000000000000000000000?id=1000000000000000000000000000000"},
+		{"fractional big.Float", bigFloat("-1.5"), "-1.5?id=-1.5"},
+		{"big.Float value", *bigFloat("1e3"), "1000?id=1000"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			Execute(ast, &out, map[string]interface{}{"id": tt.id})
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	ast, _ := parser.Parse("{var}{?var}")
+	for _, tt := range []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"large float", 1e7, "10000000?var=10000000"},
+		{"huge float", 1e21, "1000000000000000000000?var=1000000000000000000000"},
+		{"small float", 1e-7, "0.0000001?var=0.0000001"},
+		{"float32", float32(0.1), "0.1?var=0.1"},
+		{"negative float", -2.5, "-2.5?var=-2.5"},
+		{"MaxInt64", int64(math.MaxInt64), "9223372036854775807?var=9223372036854775807"},
+		{"MinInt64", int64(math.MinInt64), "-9223372036854775808?var=-9223372036854775808"},
+		{"MaxUint64", uint64(math.MaxUint64), "18446744073709551615?var=18446744073709551615"},
+		{"negative int", -42, "-42?var=-42"},
+		{"json.Number", json.Number("12.30"), "12.30?var=12.30"},
+		{"negative json.Number", json.Number("-1e3"), "-1e3?var=-1e3"},
+		{"Stringer", time.Second, "1s?var=1s"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			Execute(ast, &out, map[string]interface{}{"var": tt.value})
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type rawBytes []uint8
+
+func TestBytes(t *testing.T) {
+	for _, template := range []string{
+		"{var}", "{+var}", "{#var}", "{/var}", "{?var}", "{var:2}",
+		"{var*}", "{?var*}", "{list}", "{?map*}",
+	} {
+		t.Run(template, func(t *testing.T) {
+			ast, _ := parser.Parse(template)
+			var expected strings.Builder
+			Execute(ast, &expected, map[string]interface{}{
+				"var":  "a/b é",
+				"list": []string{"a/b", "c"},
+				"map":  map[string]string{"k": "a/b"},
+			})
+			for _, data := range []map[string]interface{}{
+				{
+					"var":  []byte("a/b é"),
+					"list": [][]byte{[]byte("a/b"), []byte("c")},
+					"map":  map[string][]byte{"k": []byte("a/b")},
+				},
+				{
+					"var":  rawBytes("a/b é"),
+					"list": []rawBytes{rawBytes("a/b"), rawBytes("c")},
+					"map":  map[string][3]byte{"k": {'a', '/', 'b'}},
+				},
+			} {
+				var out strings.Builder
+				if err := Execute(ast, &out, data); err != nil {
+					t.Fatalf("execute error: %v", err)
+				}
+				if got := out.String(); got != expected.String() {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected.String())
+				}
+			}
+		})
+	}
+}
+
+func TestBytesExplodeStrict(t *testing.T) {
+	data := map[string]interface{}{"var": []byte("ab")}
+	for _, tt := range []struct {
+		template string
+		fail     bool
+	}{
+		{"{var}", false},
+		{"{?var:1}", false},
+		{"{var*}", true},
+		{"{?var*}", true},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			err := ExecuteWith(ast, &strings.Builder{}, data, Options{Strict: true})
+			var me ModOnScalarError
+			if tt.fail != errors.As(err, &me) {
+				t.Errorf("got:\n\t%#v\nexpected a ModOnScalarError: %v", err, tt.fail)
+			}
+		})
+	}
+}
+
+func TestReserved(t *testing.T) {
+	data := map[string]interface{}{
+		"path":  Reserved("a/b c/d?e"),
+		"plain": "a/b c/d?e",
+		"list":  []Reserved{"a/b", "c"},
+		"keys":  map[string]Reserved{"k": "a/b"},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{plain}", "a%2Fb%20c%2Fd%3Fe"},
+		{"{path}", "a/b%20c/d?e"},
+		{"/files/{path:3}/{plain:3}", "/files/a/b/a%2Fb"},
+		{"{+path}", "a/b%20c/d?e"},
+		{"{/path}", "/a/b%20c/d?e"},
+		{"{?path,plain}", "?path=a/b%20c/d?e&plain=a%2Fb%20c%2Fd%3Fe"},
+		{"{list}{/list*}", "a/b,c/a/b/c"},
+		{"{?keys*}", "?k=a/b"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type Slug struct {
+	Title string
+	ID    int
+}
+
+func (s Slug) URIString() string            { return fmt.Sprintf("%d-%s", s.ID, strings.ToLower(s.Title)) }
+func (s Slug) String() string               { return s.Title }
+func (s Slug) MarshalText() ([]byte, error) { return []byte(s.Title), nil }
+
+func TestURIValue(t *testing.T) {
+	data := map[string]interface{}{
+		"post":  Slug{"Hello World", 42},
+		"ptr":   &Slug{"Ptr", 7},
+		"posts": []Slug{{"A", 1}, {"B", 2}},
+		"by":    map[string]Slug{"x": {"C", 3}},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"/posts/{post}", "/posts/42-hello%20world"},
+		{"{/ptr}", "/7-ptr"},
+		{"{?post*}", "?post=42-hello%20world"},
+		{"{/posts*}{?by*}", "/1-a/2-b?x=3-c"},
+		{"{post:4}", "42-h"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type rawValuer string
+
+func (r rawValuer) Value() (driver.Value, error) { return []byte(r), nil }
+
+type failingValuer struct{}
+
+func (failingValuer) Value() (driver.Value, error) { return nil, errors.New("no connection") }
+
+type stringValuer struct{}
+
+func (stringValuer) Value() (driver.Value, error) { return "from Value", nil }
+func (stringValuer) String() string               { return "from String" }
+
+func TestDriverValuer(t *testing.T) {
+	row := struct {
+		Name  sql.NullString `uri:"name"`
+		Email sql.NullString `uri:"email"`
+		Age   sql.NullInt64  `uri:"age"`
+		Ptr   *sql.NullString
+	}{
+		Name:  sql.NullString{String: "Gontrand", Valid: true},
+		Email: sql.NullString{String: "ignored", Valid: false},
+		Age:   sql.NullInt64{Int64: 42, Valid: true},
+		Ptr:   &sql.NullString{String: "p", Valid: true},
+	}
+	data := map[string]interface{}{
+		"row":     row,
+		"raw":     rawValuer("a b"),
+		"fail":    failingValuer{},
+		"text":    stringValuer{},
+		"invalid": sql.NullString{},
+	}
+	for _, tt := range []struct {
+		template string
+		strict   bool
+		expected string
+		err      error
+	}{
+		{"/users/{row.name}{?row.age,row.email}", false, "/users/Gontrand?age=42", nil},
+		{"{/row.Ptr}", false, "/p", nil},
+		{"{?invalid,raw}", false, "?raw=a%20b", nil},
+		{"{raw:1}", false, "a", nil},
+		{"{text}", false, "from%20String", nil},
+		{"{/fail,raw}", false, "/a%20b", nil},
+		{"{/fail,raw}", true, "", ValuerError{Path: []string{"fail"}, Err: errors.New("no connection")}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteWith(ast, &out, data, Options{Strict: tt.strict})
+			if fmt.Sprint(err) != fmt.Sprint(tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteFormat(t *testing.T) {
+	ast, _ := parser.Parse("/colors/{color}{?id,user.id,name}")
+	data := map[string]interface{}{
+		"color": []int{255, 128, 0},
+		"id":    255,
+		"user":  map[string]int{"id": 4096},
+		"name":  "Gontrand",
+	}
+	hex := func(x interface{}) string { return fmt.Sprintf("%x", x) }
+	formats := map[string]func(interface{}) string{
+		"color": func(x interface{}) string {
+			c := x.([]int)
+			return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+		},
+		"id":      hex,
+		"user.id": hex,
+		"name":    func(x interface{}) string { return "Mr " + x.(string) },
+	}
+	expected := "/colors/%23ff8000?id=ff&id=1000&name=Mr%20Gontrand"
+
+	var out strings.Builder
+	if err := ExecuteFormat(ast, &out, data, formats); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if got := out.String(); got != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+}
@@ -0,0 +1,179 @@
+//go:build go1.18
+// +build go1.18
+
+package execute
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// fuzzTemplates use every operator and modifier on the variables a and b,
+// and paths into them.
+var fuzzTemplates = []string{
+	"{a}{+a}{#a}{.a}{/a}{;a}{?a}{&a}",
+	"{a*}{+a*}{#a*}{.a*}{/a*}{;a*}{?a*}{&a*}",
+	"{a:1}{+a:3}{#b:2}{.b:9999}{/a:5,b*}{;b:1}{?a:2,b}{&b*}",
+	"/x/{a.b}/{a.0.c}{?a.b.c,b.1,b.k.0.x}{&a.Value,b.ID}",
+	"{a,b}{;a*,b*}{?missing,a,b:1}",
+}
+
+// fuzzData turns a JSON document into the data of an expansion, using
+// choices to pick an unusual Go representation for each of its values. A
+// document that is not an object is given to the variables a and b.
+func fuzzData(doc []byte, choices []byte) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		v = string(doc)
+	}
+	c := &fuzzChoices{choices: choices}
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, x := range m {
+			m[k] = c.convert(x)
+		}
+		return m
+	}
+	return map[string]interface{}{"a": c.convert(v), "b": c.convert(v)}
+}
+
+type fuzzChoices struct {
+	choices []byte
+	i       int
+}
+
+func (c *fuzzChoices) next() byte {
+	if len(c.choices) == 0 {
+		return 0
+	}
+	b := c.choices[c.i%len(c.choices)]
+	c.i++
+	return b
+}
+
+type fuzzStruct struct {
+	Value interface{} `uri:"value"`
+	ID    *int
+	Self  *fuzzStruct
+}
+
+func (c *fuzzChoices) convert(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return []interface{}{
+			nil, (*string)(nil), (chan int)(nil), map[string]string(nil),
+			[]int(nil), (func())(nil), (*fuzzStruct)(nil), Reserved(""),
+		}[c.next()%8]
+	case bool:
+		if c.next()%2 == 0 {
+			return &v
+		}
+		return v
+	case float64:
+		switch c.next() % 6 {
+		case 0:
+			return int(v)
+		case 1:
+			return uint8(v)
+		case 2:
+			i := int64(v)
+			return &i
+		case 3:
+			return float32(v)
+		case 4:
+			return math.NaN()
+		}
+		return v
+	case string:
+		switch c.next() % 5 {
+		case 0:
+			return Reserved(v)
+		case 1:
+			return []byte(v)
+		case 2:
+			return &v
+		case 3:
+			return json.Number(v)
+		}
+		return v
+	case []interface{}:
+		for i, x := range v {
+			v[i] = c.convert(x)
+		}
+		switch c.next() % 3 {
+		case 0:
+			return &v
+		case 1:
+			if len(v) > 0 {
+				return [1]interface{}{v[0]}
+			}
+		}
+		return v
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = c.convert(x)
+		}
+		switch c.next() % 4 {
+		case 0:
+			return &v
+		case 1:
+			m := make(map[interface{}]interface{}, len(v))
+			for k, x := range v {
+				m[k] = x
+			}
+			return m
+		case 2:
+			s := &fuzzStruct{Value: v["value"]}
+			s.Self = s
+			return s
+		}
+		return v
+	}
+	return v
+}
+
+func FuzzExecute(f *testing.F) {
+	for _, seed := range []string{
+		`{"a": "Gontrand", "b": ["x", "y"]}`,
+		`{"a": {"city": "Paris", "state": "IDF", "Country": "France"}}`,
+		`{"a": {"address": {"city": "Paris"}}, "b": [{"name": "a", "value": "1"}]}`,
+		`{"a": {"firstName": "Gontrand", "lastName": "Fauxfilet"}, "b": null}`,
+		`{"a": ["a", null, {"ch": null}], "b": {"a": "b", "ch": null}}`,
+		`{"a": {"b": {"c": [1, 2.5, -3e20]}}, "b": [[], {}, "", false]}`,
+		`[{"value": {"value": null}}, "€", 1e308]`,
+		`"plain string"`,
+		`null`,
+	} {
+		f.Add([]byte(seed), []byte{0, 1, 2, 3, 4, 5, 6, 7})
+	}
+	var asts []*parser.Ast
+	for _, template := range fuzzTemplates {
+		ast, err := parser.Parse(template)
+		if err != nil {
+			f.Fatalf("parse error: %v", err)
+		}
+		asts = append(asts, ast)
+	}
+	f.Fuzz(func(t *testing.T, doc []byte, choices []byte) {
+		for _, opts := range []Options{
+			{},
+			{Strict: true, AllowMethods: true},
+			{Partial: true, EscapeKeys: true, MaxLen: 64},
+		} {
+			for _, ast := range asts {
+				var out strings.Builder
+				ExecuteWith(ast, &out, fuzzData(doc, choices), opts)
+				if !utf8.ValidString(out.String()) {
+					t.Errorf("invalid UTF-8 output:\n\t%q", out.String())
+				}
+				ExecuteQuery(ast, fuzzData(doc, choices))
+				ExecuteWith(ast, io.Discard, fuzzData(doc, choices), opts)
+			}
+		}
+	})
+}
@@ -0,0 +1,238 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// Matcher reads the values of the variables of a template back from the
+// URIs expanded from it, like a router does with the paths of its routes.
+// It is the reverse of the expansion with the default Options, and only of
+// scalar values: a URI where an expression holds more values than it has
+// variables, like an expanded list, does not match, and neither does a
+// value holding its expression’s variable separator unescaped, like a '.'
+// under the '.' operator.
+//
+// Several variables of an expression without names are told apart by their
+// order only: "{a,b}" matching "x" gives a, not b. With the operators that
+// let the reserved characters through, '+' and '#', whose values may hold
+// commas, the last variable keeps the extra values. The keys of adjacent
+// expressions with names and the same separator, like "{?a}{&b}", may come
+// in any order.
+//
+// A Matcher can be used from several goroutines at once.
+type Matcher struct {
+	re    *regexp.Regexp
+	exprs []matchedExpr // the expressions, in the order of the groups of re
+}
+
+// matchedExpr is an expression of the template of a Matcher.
+type matchedExpr struct {
+	op   byte
+	vars []parser.Var // its variables
+	keys []parser.Var // the variables its keys may name, if it has names
+}
+
+// CompileMatcher returns the Matcher of the template.
+func CompileMatcher(ast *parser.Ast) *Matcher {
+	m := &Matcher{}
+	var re strings.Builder
+	re.WriteString(`^`)
+	chain := 0 // the first expression whose keys the next one shares
+	for _, part := range ast.Parts {
+		switch part := part.(type) {
+		case string:
+			re.WriteString(regexp.QuoteMeta(parser.EscapeLiteral(part)))
+			chain = len(m.exprs)
+		case nil:
+			re.WriteString(regexp.QuoteMeta((&Options{}).separator()))
+			chain = len(m.exprs)
+		default:
+			expr, ok := parser.ExprPart(part)
+			if !ok {
+				continue
+			}
+			rules := operatorOf(expr.Op)
+			if chain < len(m.exprs) && operatorOf(m.exprs[chain].op).varsep != rules.varsep {
+				chain = len(m.exprs)
+			}
+			m.exprs = append(m.exprs, matchedExpr{op: expr.Op, vars: expr.Vars})
+			re.WriteString(exprPattern(expr.Op))
+			if !rules.named {
+				chain = len(m.exprs)
+				break
+			}
+			var keys []parser.Var
+			for _, prev := range m.exprs[chain:] {
+				keys = append(keys, prev.vars...)
+			}
+			for i := chain; i < len(m.exprs); i++ {
+				m.exprs[i].keys = keys
+			}
+		}
+	}
+	re.WriteString(`$`)
+	m.re = regexp.MustCompile(re.String())
+	return m
+}
+
+// exprPattern returns the group of the regular expression matching what the
+// expressions with the operator op expand to: their sign, if they have one
+// and any variable is defined, then the characters that their values, keys
+// and separators are made of, as any client may write them. The delimiters
+// of the path segments, of the query and of the fragment are left out,
+// except where reserved expansion lets them through, so that the literals
+// and expressions that follow can match them.
+func exprPattern(op byte) string {
+	rules := operatorOf(op)
+	allowed := "%,!$'()*+:@-._~" + string(rules.varsep)
+	if rules.named {
+		allowed += "="
+	}
+	if rules.mask&escape.Reserved == 0 {
+		allowed += "/;&=[]"
+		if op == '#' {
+			allowed += "?#"
+		}
+	}
+	var class strings.Builder
+	class.WriteString(`[0-9A-Za-z`)
+	for i := 0; i < len(allowed); i++ {
+		fmt.Fprintf(&class, `\x%02x`, allowed[i])
+	}
+	class.WriteString(`]*`)
+	if rules.sign == 0 {
+		return `(` + class.String() + `)`
+	}
+	return `((?:` + regexp.QuoteMeta(string(rules.sign)) + class.String() + `)?)`
+}
+
+// Match is like the method Match of CompileMatcher(ast).
+func Match(ast *parser.Ast, uri string) (map[string]string, bool) {
+	return CompileMatcher(ast).Match(uri)
+}
+
+// Match reads the values of the variables back from uri, and reports
+// whether uri matches the template. The values are keyed by the dotted names
+// of their variables; the undefined variables are left out. They are
+// decoded with escape.Unescape, or escape.UnescapeLenient for the operators
+// that let reserved characters through, '+' and '#'. A value that does not
+// decode does not match.
+func (m *Matcher) Match(uri string) (map[string]string, bool) {
+	return m.MatchWith(uri, nil)
+}
+
+// MatchWith is like Match, but decodes the values of the expressions with
+// the operator op with decoders[op], if it is set, 0 being the operator of
+// the expressions without one. For instance, escape.UnescapePlus for '?'
+// and '&' reads the spaces that HTML forms write as '+' in the queries.
+func (m *Matcher) MatchWith(uri string, decoders map[byte]escape.Decoder) (map[string]string, bool) {
+	groups := m.re.FindStringSubmatch(uri)
+	if groups == nil {
+		return nil, false
+	}
+	values := map[string]string{}
+	for i, expr := range m.exprs {
+		decode := decoders[expr.op]
+		if decode == nil {
+			decode = defaultDecoder(expr.op)
+		}
+		if !matchExpr(values, &m.exprs[i], groups[i+1], decode) {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// defaultDecoder returns the Decoder of the values of the expressions with
+// the operator op, when MatchWith is given none.
+func defaultDecoder(op byte) escape.Decoder {
+	if operatorOf(op).mask&escape.Reserved == 0 {
+		return func(s string) (string, error) { return escape.UnescapeLenient(s), nil }
+	}
+	return escape.Unescape
+}
+
+// matchExpr decodes the values that the expression expanded to into values,
+// and reports whether they fit its variables.
+func matchExpr(values map[string]string, expr *matchedExpr, s string, decode escape.Decoder) bool {
+	rules := operatorOf(expr.op)
+	if rules.sign != 0 {
+		if s == "" {
+			return true
+		}
+		s = s[1:]
+	} else if s == "" {
+		return true
+	}
+	items := strings.Split(s, string(rules.varsep))
+	if !rules.named {
+		if len(items) > len(expr.vars) {
+			if rules.mask&escape.Reserved != 0 {
+				return false
+			}
+			// reserved expansion lets the commas of the values through
+			last := len(expr.vars) - 1
+			items[last] = strings.Join(items[last:], string(rules.varsep))
+			items = items[:last+1]
+		}
+		for i, item := range items {
+			if !decodeValue(values, &expr.vars[i], item, decode) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, item := range items {
+		key, value := item, ""
+		if i := strings.IndexByte(item, '='); i >= 0 {
+			key, value = item[:i], item[i+1:]
+		} else if expr.op != ';' {
+			return false
+		}
+		v := namedVar(expr.keys, key)
+		if v == nil || !decodeValue(values, v, value, decode) {
+			return false
+		}
+	}
+	return true
+}
+
+// namedVar returns the variable written with the key, as the named
+// operators write them, or nil if there is none.
+func namedVar(vars []parser.Var, key string) *parser.Var {
+	for i := range vars {
+		if v := &vars[i]; v.ID[len(v.ID)-1] == key {
+			return v
+		}
+	}
+	return nil
+}
+
+// decodeValue decodes the value of the variable v into values, and reports
+// whether it decoded and agrees with the value v was given before, if any.
+func decodeValue(values map[string]string, v *parser.Var, s string, decode escape.Decoder) bool {
+	value, err := decode(s)
+	if err != nil {
+		return false
+	}
+	name := strings.Join(v.ID, ".")
+	if prev, ok := values[name]; ok && prev != value {
+		return false
+	}
+	values[name] = value
+	return true
+}
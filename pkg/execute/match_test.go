@@ -0,0 +1,109 @@
+package execute
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+func TestMatch(t *testing.T) {
+	type m = map[string]string
+	for _, tt := range []struct {
+		template string
+		uri      string
+		expected map[string]string
+	}{
+		{"/users/{id}/posts{?page,per_page}", "/users/42/posts?page=2", m{"id": "42", "page": "2"}},
+		{"/users/{id}/posts{?page,per_page}", "/users/42/posts?per_page=5&page=2", m{"id": "42", "page": "2", "per_page": "5"}},
+		{"/users/{id}/posts{?page,per_page}", "/users/42/posts", m{"id": "42"}},
+		{"/users/{id}/posts{?page,per_page}", "/users/42/comments", nil},
+		{"/users/{id}/posts{?page,per_page}", "/users/42/posts?sort=date", nil},
+		{"/users/{id}", "/users/a%20b", m{"id": "a b"}},
+		{"/users/{id}", "/users/a/b", nil},
+		{"/users/{id}", "/users/a%zz", nil},
+		{"{a,b}", "x", m{"a": "x"}},
+		{"{a,b}", "x,y", m{"a": "x", "b": "y"}},
+		{"{a,b}", "x,y,z", nil},
+		{"{a}/{a}", "x/x", m{"a": "x"}},
+		{"{a}/{a}", "x/y", nil},
+		{"{/user.name,id}", "/gontrand/42", m{"user.name": "gontrand", "id": "42"}},
+		{"{;x,y,z}", ";x=1;z", m{"x": "1", "z": ""}},
+		{"{+path}{?q}", "/a/b,c?q=1", m{"path": "/a/b,c", "q": "1"}},
+		{"{+path}", "/100%/a%20b", m{"path": "/100%/a b"}},
+		{"/x{#frag}", "/x#a/b?c", m{"frag": "a/b?c"}},
+		{"/x{#frag}", "/x", m{}},
+		{"{?q}{&r}", "?q=1&r=2", m{"q": "1", "r": "2"}},
+		{"{?q}{&r}", "?r=2&q=1", m{"q": "1", "r": "2"}},
+		{"{?q}/{&r}", "?r=2/", nil},
+		{"{.a}", ".b.c", nil},
+	} {
+		t.Run(tt.template+" "+tt.uri, func(t *testing.T) {
+			ast, err := parser.Parse(tt.template)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			got, ok := Match(ast, tt.uri)
+			if ok != (tt.expected != nil) || !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMatchNilExpr(t *testing.T) {
+	parsed, _ := parser.Parse("/users/{id}{?page}")
+	ast := &parser.Ast{Vars: parsed.Vars}
+	for _, part := range parsed.Parts {
+		if expr, ok := part.(parser.Expr); ok {
+			ast.Parts = append(ast.Parts, (*parser.Expr)(nil))
+			part = &expr
+		}
+		ast.Parts = append(ast.Parts, part)
+	}
+	expected := map[string]string{"id": "42", "page": "2"}
+	if got, ok := Match(ast, "/users/42?page=2"); !ok || !reflect.DeepEqual(got, expected) {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+}
+
+func TestMatchRoundTrip(t *testing.T) {
+	data := map[string]string{
+		"id":   "foo bar",
+		"path": "/a b/ü",
+		"q":    "50% & more",
+		"f":    "x#y",
+	}
+	ast, _ := parser.Parse("/items/{id}{+path}{?q}{#f}")
+	uri, err := ExecuteString(ast, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, ok := Match(ast, uri)
+	if !ok || !reflect.DeepEqual(got, data) {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, data)
+	}
+}
+
+func TestMatchWith(t *testing.T) {
+	ast, _ := parser.Parse("/search{?q}")
+	plus := map[byte]escape.Decoder{'?': escape.UnescapePlus}
+	for _, tt := range []struct {
+		uri      string
+		decoders map[byte]escape.Decoder
+		expected string
+	}{
+		{"/search?q=a+b", nil, "a+b"},
+		{"/search?q=a+b", plus, "a b"},
+		{"/search?q=a%2Bb+c", plus, "a+b c"},
+		{"/search?q=a%20b", plus, "a b"},
+	} {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, ok := CompileMatcher(ast).MatchWith(tt.uri, tt.decoders)
+			if !ok || got["q"] != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got["q"], tt.expected)
+			}
+		})
+	}
+}
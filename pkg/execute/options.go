@@ -0,0 +1,287 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// Options changes the behaviour of ExecuteWith.
+// The zero value expands templates exactly like Execute.
+type Options struct {
+	// RequirePathVars makes undefined variables an error, except under the
+	// query operators '?' and '&', where they are still silently dropped.
+	// This matches how routers think about URLs: path variables are
+	// mandatory, query variables are optional.
+	RequirePathVars bool
+
+	// Formats maps variable names, dotted if qualified, to functions that
+	// render their whole value as a scalar, replacing the default logic.
+	// The result is still escaped according to the operator.
+	Formats map[string]func(interface{}) string
+
+	// SeparatorString is written in place of each path separator of the
+	// template, that is each '/' outside of expressions. It defaults to "/".
+	// Slashes produced by the '/' operator are not affected.
+	SeparatorString string
+
+	// Partial makes undefined variables expand back to themselves, so that
+	// the output is a template for the variables that are still missing.
+	// RequirePathVars is ignored.
+	//
+	// When only some variables of an expression are defined, they are
+	// expanded first, and the undefined ones follow in a second expression
+	// with the same operator, like other partial expansion implementations
+	// do. '?' becomes '&' in the second expression, so that it does not
+	// repeat the sign already written. For "{/a,b,c}" with only a and c
+	// defined, this gives "/A/C{/b}", and the variables may thus change
+	// order.
+	//
+	// The operators that separate values with commas (none, '+' and '#')
+	// would lose the comma between the two expressions, so their expressions
+	// are written back whole instead: "{a,b}" with only a defined gives
+	// "{a,b}", and the values of its defined variables must be given again.
+	Partial bool
+
+	// Strict makes an error of the modifiers that do not apply to the value
+	// of their variable:
+	//   - the explode modifier on bytes, which are a scalar, is otherwise
+	//     ignored;
+	//   - the prefix modifier on lists and associative arrays, which RFC 6570
+	//     forbids, is otherwise ignored.
+	// It also makes an IndexError of the parts of variable names that index
+	// a list out of its range, such as "{items.3}" for a list of three
+	// items, and a KeyConversionError of the parts that do not convert to
+	// the key type of a map, such as "{ports.http}" for a map with integer
+	// keys, which are both otherwise undefined, a NestedCompositeError of the
+	// lists holding associative arrays that are not exploded, a FormatError
+	// of the failing MarshalText methods, an UnsupportedValueError of the
+	// functions and channels, and a LimitError of the cyclic values.
+	Strict bool
+
+	// MaxLen is the maximum length of the output in bytes, or zero for no
+	// limit. An expansion that would exceed it stops with a TooLongError.
+	// The output is written part by part: the literals and expressions that
+	// fit are written, and the part that would exceed the limit is
+	// discarded as a whole, so the output is cut at a part boundary.
+	// Expressions stop being built as soon as they are too long.
+	MaxLen int
+
+	// ValidateValue, if not nil, is called with each value about to be
+	// written, rendered but not escaped yet, along with its variable and the
+	// operator of its expression. Lists and associative arrays have their
+	// values checked one by one. An error stops the expansion, wrapped in an
+	// InvalidValueError. See RejectDotSegments.
+	ValidateValue func(v *parser.Var, op byte, rendered string) error
+
+	// EscapeKeys makes the named operators ';', '?' and '&' escape the
+	// variable names they write as keys, like they escape values. Names
+	// given by the parser can only hold characters that need no escaping
+	// and %XX sequences, which are kept as is; this is for the names of an
+	// Ast built by hand.
+	EscapeKeys bool
+
+	// AllowMethods lets the parts of variable names that match no field of
+	// a struct resolve to the result of a getter method instead: a niladic
+	// exported method named exactly like the part, or like the part
+	// prefixed with "Get" and capitalized, such as ID for "{ID}" or GetName
+	// for "{name}", which returns one value, optionally followed by an
+	// error. Methods with value and pointer receivers are both found. A
+	// getter that returns an error leaves its variable undefined, or fails
+	// the expansion with a MethodError in strict mode.
+	AllowMethods bool
+
+	// MaskFor, if not nil, is asked for the escape mask of each operator
+	// before the built-in one, which is used when it returns false. The
+	// mask is given to escape.Escape for the values of the expressions
+	// with that operator, 0 being the operator of the expressions without
+	// one. For instance, escape.Disallowed alone under '.' keeps the
+	// reserved characters, such as the '+' of versioned file names, while
+	// still escaping spaces. Like with '+' and '#', the masks without
+	// escape.Reserved keep the pct-encoded triplets of values.
+	//
+	// Masks that escape less than the built-in ones produce output that
+	// does not follow RFC 6570, and may not even be a valid URI.
+	MaskFor func(op byte) (mask byte, ok bool)
+
+	// OmitZero makes undefined the scalars holding the zero value of their
+	// type, such as 0, "" and false, and the values whose IsZero method
+	// returns true, such as the zero time.Time. It applies to every
+	// variable, like the "omitempty" option of encoding/json applies to a
+	// field, so that "{?page}" expands to nothing for a Page of 0. Zero
+	// values reached through a pointer were set on purpose, and are still
+	// expanded: a *int pointing to 0 gives "?page=0". Lists and associative
+	// arrays are not affected, and are undefined only when empty.
+	OmitZero bool
+
+	// EncodeLiterals makes the literal parts of templates escaped like the
+	// values of the '+' operator: the characters that are not allowed
+	// anywhere in a URI are percent-encoded, while the reserved ones, such
+	// as '?' and '&', are kept. Templates may hold such characters, which
+	// are written as is otherwise, like the 'é' of "/café{/x}" written as
+	// raw UTF-8. The %XX sequences of templates are kept.
+	EncodeLiterals bool
+
+	// Defaults maps the head names of variables to the values they take
+	// when they are undefined. The rest of a qualified name is searched in
+	// the default of its head: with a default for "user", "{user.id}" falls
+	// back to the id of that default when it is undefined in the data.
+	Defaults map[string]interface{}
+
+	// Required lists the variables, dotted if qualified, that must be
+	// defined, after Defaults, for the expansion to happen at all. They are
+	// checked before anything is written, whether they appear in the
+	// template or not, even under the operators that drop undefined
+	// variables. A RequiredError lists all those that are missing.
+	Required []string
+
+	// OnUndefined, if not nil, is called with each undefined variable, in
+	// the order of the template, after Defaults, along with its expression,
+	// which it must not keep nor modify. It returns false for the variable
+	// to stay undefined, or its replacement value, which is then expanded
+	// like any other. The undefined items of lists and associative arrays
+	// are left out without a call.
+	OnUndefined func(path []string, expr *parser.Expr) (replacement interface{}, ok bool)
+
+	// FlatKeys makes the qualified variables that are not found by walking
+	// the data part by part looked up again with their whole dotted name,
+	// as a key of the data itself. This lets flat maps, such as the
+	// map[string]string{"db.host": "localhost"} of a configuration file,
+	// define "{db.host}". Nested values still take precedence. It does not
+	// apply to a Resolver, which is given the whole name already.
+	FlatKeys bool
+
+	// UndefinedPlaceholder, if not empty, is written in place of the
+	// undefined variables, escaped like a value, such as "_" for a missing
+	// path segment: "{/a,b}" with only a defined gives "/A/_". The query
+	// operators '?' and '&' still drop them, and RequirePathVars and
+	// Partial take precedence. Modifiers do not apply to it.
+	UndefinedPlaceholder string
+
+	// DropEmptyQueryValues makes the named operators ';', '?' and '&' leave
+	// out the scalars that render as the empty string, key included, for the
+	// APIs that read "?q=" differently than no q at all. Unlike OmitZero,
+	// it applies to empty strings reached through a pointer too, and only
+	// to them: "{?q,page}" with an empty q and a page of 0 gives "?page=0".
+	// The items of lists and associative arrays are not affected.
+	DropEmptyQueryValues bool
+}
+
+// separator returns the string to write for the path separators.
+func (o *Options) separator() string {
+	if o.SeparatorString == "" {
+		return "/"
+	}
+	return o.SeparatorString
+}
+
+// operator returns the expansion rules of op, with the mask given by MaskFor
+// if any.
+func (o *Options) operator(op byte) operator {
+	rules := operatorOf(op)
+	if o.MaskFor != nil {
+		if mask, ok := o.MaskFor(op); ok {
+			rules.mask = mask
+		}
+	}
+	return rules
+}
+
+// ErrDotSegment is returned by RejectDotSegments.
+var ErrDotSegment = errors.New("value would change the path hierarchy")
+
+// RejectDotSegments is a validator for Options.ValidateValue keeping each
+// value within its path segment: it rejects the values "." and "..", which
+// would be read as dot-segments, and under the '+' and '#' operators, which
+// do not encode reserved characters, the values containing a '/'. As these
+// operators keep the pct-encoded triplets of values, they are checked once
+// decoded: the values holding an encoded '/', such as "a%2Fb", and the ones
+// that decode to "." or "..", such as "%2E%2E", are rejected under them too.
+func RejectDotSegments(v *parser.Var, op byte, rendered string) error {
+	if op == '+' || op == '#' {
+		rendered = escape.UnescapeLenient(rendered)
+		if strings.IndexByte(rendered, '/') >= 0 {
+			return ErrDotSegment
+		}
+	}
+	if rendered == "." || rendered == ".." {
+		return ErrDotSegment
+	}
+	return nil
+}
+
+// An Option sets some of the Options, for ExecuteOpts.
+type Option func(*Options)
+
+// WithStrict sets Options.Strict.
+func WithStrict() Option {
+	return func(o *Options) { o.Strict = true }
+}
+
+// WithPartial sets Options.Partial.
+func WithPartial() Option {
+	return func(o *Options) { o.Partial = true }
+}
+
+// WithRequirePathVars sets Options.RequirePathVars.
+func WithRequirePathVars() Option {
+	return func(o *Options) { o.RequirePathVars = true }
+}
+
+// WithOmitZero sets Options.OmitZero.
+func WithOmitZero() Option {
+	return func(o *Options) { o.OmitZero = true }
+}
+
+// WithEncodeLiterals sets Options.EncodeLiterals.
+func WithEncodeLiterals() Option {
+	return func(o *Options) { o.EncodeLiterals = true }
+}
+
+// WithMaxLen sets Options.MaxLen to n.
+func WithMaxLen(n int) Option {
+	return func(o *Options) { o.MaxLen = n }
+}
+
+// WithMaskFor sets Options.MaskFor to maskFor.
+func WithMaskFor(maskFor func(op byte) (mask byte, ok bool)) Option {
+	return func(o *Options) { o.MaskFor = maskFor }
+}
+
+// WithFormat adds format to Options.Formats for name, replacing the one
+// already set for it, if any.
+func WithFormat(name string, format func(interface{}) string) Option {
+	return func(o *Options) {
+		if o.Formats == nil {
+			o.Formats = make(map[string]func(interface{}) string)
+		}
+		o.Formats[name] = format
+	}
+}
+
+// WithDefault adds value to Options.Defaults for the head name name,
+// replacing the one already set for it, if any.
+func WithDefault(name string, value interface{}) Option {
+	return func(o *Options) {
+		if o.Defaults == nil {
+			o.Defaults = make(map[string]interface{})
+		}
+		o.Defaults[name] = value
+	}
+}
+
+// WithRequired adds names to Options.Required.
+func WithRequired(names ...string) Option {
+	return func(o *Options) { o.Required = append(o.Required, names...) }
+}
@@ -0,0 +1,735 @@
+package execute
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+func TestRequirePathVars(t *testing.T) {
+	data := map[string]interface{}{
+		"id":   "42",
+		"page": "2",
+	}
+	opts := Options{RequirePathVars: true}
+	for _, tt := range []struct {
+		template string
+		expected string
+		missing  []string
+	}{
+		{"/users/{id}{?page,sort}", "/users/42?page=2", nil},
+		{"/users/{id}{?page}{&sort}", "/users/42?page=2", nil},
+		{"/users/{user.id}", "", []string{"user", "id"}},
+		{"/users/{name}", "", []string{"name"}},
+		{"/users{/id,name}", "", []string{"name"}},
+		{"/users/{+name}", "", []string{"name"}},
+		{"/users/{#name}", "", []string{"name"}},
+		{"/users/{.name}", "", []string{"name"}},
+		{"/users/{;name}", "", []string{"name"}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteWith(ast, &out, data, opts)
+			if tt.missing == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := out.String(); got != tt.expected {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+				}
+				return
+			}
+			var re ResolveError
+			if !errors.As(err, &re) {
+				t.Fatalf("expected a ResolveError, got:\n\t%#v", err)
+			}
+			if !reflect.DeepEqual(re.Path, tt.missing) {
+				t.Errorf("got path:\n\t%q\nexpected:\n\t%q", re.Path, tt.missing)
+			}
+		})
+	}
+}
+
+func TestSeparatorString(t *testing.T) {
+	data := map[string]interface{}{
+		"a":    "x",
+		"list": []string{"y", "z"},
+	}
+	for _, tt := range []struct {
+		template  string
+		separator string
+		expected  string
+	}{
+		{"a/b/c", "", "a/b/c"},
+		{"a/b/c", "\\", `a\b\c`},
+		{"a/b/c", ".", "a.b.c"},
+		{"/{a}/c/", "::", "::x::c::"},
+		{"{a}/{+list}", "\\", `x\y,z`},
+		{"{a}{/list*}", "\\", "x/y/z"},
+	} {
+		t.Run(tt.template+" "+tt.separator, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteWith(ast, &out, data, Options{SeparatorString: tt.separator})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPartial(t *testing.T) {
+	first := map[string]interface{}{
+		"region": "eu",
+		"page":   "2",
+		"a":      "A",
+		"c":      "C",
+		"list":   []string{},
+	}
+	second := map[string]interface{}{
+		"id":   "42",
+		"sort": "date",
+		"b":    "B",
+		"list": []string{"x", "y"},
+	}
+	both := map[string]interface{}{}
+	for k, v := range first {
+		both[k] = v
+	}
+	for k, v := range second {
+		both[k] = v
+	}
+	for _, tt := range []struct {
+		template string
+		partial  string
+		expected string
+	}{
+		{"{region}/thing/{id}", "eu/thing/{id}", "eu/thing/42"},
+		{"{id:3}/{list*}", "{id:3}/{list*}", "42/x,y"},
+		{"{a,b,c}", "{a,b,c}", "A,B,C"},
+		{"{+a,b}", "{+a,b}", "A,B"},
+		{"{#a,b}", "{#a,b}", "#A,B"},
+		{"{#a:1,b*}/{+c}", "{#a:1,b*}/C", "#A,B/C"},
+		{"{#b}", "{#b}", "#B"},
+		{"/things{?page,sort}", "/things?page=2{&sort}", "/things?page=2&sort=date"},
+		{"/things{?sort,page}", "/things?page=2{&sort}", "/things?page=2&sort=date"},
+		{"/things{?sort,id}", "/things{?sort,id}", "/things?sort=date&id=42"},
+		{"/things{?page}{&sort}", "/things?page=2{&sort}", "/things?page=2&sort=date"},
+		{"{/a,b,c}", "/A/C{/b}", "/A/C/B"},
+		{"{/b,id}", "{/b,id}", "/B/42"},
+		{"{/region}{/list*}", "/eu{/list*}", "/eu/x/y"},
+		{"{;a,b}", ";a=A{;b}", ";a=A;b=B"},
+		{"{.a,user.name}", ".A{.user.name}", ".A"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			opts := Options{Partial: true, RequirePathVars: true}
+			if err := ExecuteWith(ast, &out, first, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := out.String(); got != tt.partial {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.partial)
+			}
+			ast, err := parser.Parse(out.String())
+			if err != nil {
+				t.Fatalf("partial output does not parse: %v", err)
+			}
+			out.Reset()
+			Execute(ast, &out, both)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("second stage got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStrictPrefix(t *testing.T) {
+	data := map[string]interface{}{
+		"list": []string{"red", "green"},
+		"keys": map[string]string{"semi": ";;", "dot": ".."},
+		"var":  "value",
+	}
+	for _, tt := range []struct {
+		template string
+		lenient  string
+		fail     bool
+	}{
+		{"{list:2}", "red,green", true},
+		{"{?list:2}", "?list=red,green", true},
+		{"{/list:2}", "/red,green", true},
+		{"{+list:1}", "red,green", true},
+		{"{.list:1}", ".red,green", true},
+		{"{;list:1,var:3}", ";list=red,green;var=val", true},
+		{"{#list:1}{&list:1}", "#red,green&list=red,green", true},
+		{"{keys:2}", "dot,..,semi,%3B%3B", true},
+		{"{?keys:2}", "?keys=dot,..,semi,%3B%3B", true},
+		{"{/keys:1}", "/dot,..,semi,%3B%3B", true},
+		{"{+keys:1}", "dot,..,semi,;;", true},
+		{"{list*}{var:2}", "red,greenva", false},
+		{"{empty:2}", "", false},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			if err := Execute(ast, &out, data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := out.String(); got != tt.lenient {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.lenient)
+			}
+			err := ExecuteWith(ast, &strings.Builder{}, data, Options{Strict: true})
+			var me ModOnCompositeError
+			if tt.fail != errors.As(err, &me) {
+				t.Errorf("got:\n\t%#v\nexpected a ModOnCompositeError: %v", err, tt.fail)
+			}
+		})
+	}
+}
+
+func TestMaxLen(t *testing.T) {
+	huge := make([]string, 100000)
+	for i := range huge {
+		huge[i] = "item"
+	}
+	data := map[string]interface{}{
+		"id":   "42",
+		"list": huge,
+		"big":  strings.Repeat("x", 1000000),
+	}
+	for _, tt := range []struct {
+		template string
+		max      int
+		expected string
+		part     int // -1 if the expansion fits
+	}{
+		{"/items/{id}", 9, "/items/42", -1},
+		{"/items/{id}", 0, "/items/42", -1},
+		{"/items/{id}", 8, "/items/", 3},
+		{"/items/{id}/long-literal", 12, "/items/42/", 5},
+		{"/items/{id}/", 9, "/items/42", 4},
+		{"/items/{id}{?list*}", 1000, "/items/42", 4},
+		{"{list}", 1000, "", 0},
+		{"/{big}", 1000, "/", 1},
+		{"{id,big:998}", 1000, "", 0},
+		{"{id,big:997}", 1000, "42," + strings.Repeat("x", 997), -1},
+	} {
+		t.Run(fmt.Sprint(tt.template, tt.max), func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteWith(ast, &out, data, Options{MaxLen: tt.max})
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			if tt.part < 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var tle TooLongError
+			if !errors.As(err, &tle) {
+				t.Fatalf("expected a TooLongError, got:\n\t%#v", err)
+			}
+			if tle.Limit != tt.max || tle.Part != tt.part {
+				t.Errorf("got:\n\t%#v\nexpected limit %d at part %d", tle, tt.max, tt.part)
+			}
+		})
+	}
+}
+
+func TestRejectDotSegments(t *testing.T) {
+	opts := Options{ValidateValue: RejectDotSegments}
+	for _, tt := range []struct {
+		template string
+		value    interface{}
+		expected string
+		fail     bool
+	}{
+		{"/files{/name}", "report.pdf", "/files/report.pdf", false},
+		{"/files{/name}", "..", "", true},
+		{"/files{/name}", ".", "", true},
+		{"/files/{name}", "...", "/files/...", false},
+		{"/files{/name*}", []string{"a", ".."}, "", true},
+		{"/files{/name:2}", "..hidden", "", true},
+		{"/files/{name}", "a/b", "/files/a%2Fb", false},
+		{"/files/{+name}", "a/b", "", true},
+		{"/files{#name}", "a/b", "", true},
+		{"/files/{+name}", "a%2Fb", "", true},
+		{"/files/{+name}", "..%2F..%2Fetc", "", true},
+		{"/files{#name}", "%2f", "", true},
+		{"/files/{+name}", "%2E%2e", "", true},
+		{"/files/{name}", "%2E%2E", "/files/%252E%252E", false},
+	} {
+		t.Run(fmt.Sprint(tt.template, tt.value), func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteWith(ast, &out, map[string]interface{}{"name": tt.value}, opts)
+			if !tt.fail {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := out.String(); got != tt.expected {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+				}
+				return
+			}
+			var ive InvalidValueError
+			if !errors.As(err, &ive) || !errors.Is(err, ErrDotSegment) {
+				t.Fatalf("expected an InvalidValueError, got:\n\t%#v", err)
+			}
+			if !reflect.DeepEqual(ive.Path, []string{"name"}) {
+				t.Errorf("got path:\n\t%q\nexpected:\n\t%q", ive.Path, []string{"name"})
+			}
+		})
+	}
+}
+
+func TestEscapeKeys(t *testing.T) {
+	data := map[string]interface{}{
+		"a&b":   "1",
+		"a%20b": "2",
+		"%zz":   "3",
+		"user":  map[string]interface{}{"first name": "Gontrand"},
+		"x=y;z": "4",
+	}
+	for _, tt := range []struct {
+		expr     parser.Expr
+		raw      string
+		expected string
+	}{
+		{parser.Expr{Op: '?', Vars: []parser.Var{{ID: []string{"a&b"}}}}, "?a&b=1", "?a%26b=1"},
+		{parser.Expr{Op: '&', Vars: []parser.Var{{ID: []string{"a%20b"}}}}, "&a%20b=2", "&a%20b=2"},
+		{parser.Expr{Op: '?', Vars: []parser.Var{{ID: []string{"%zz"}}}}, "?%zz=3", "?%25zz=3"},
+		{parser.Expr{Op: ';', Vars: []parser.Var{{ID: []string{"x=y;z"}}}}, ";x=y;z=4", ";x%3Dy%3Bz=4"},
+		{parser.Expr{Op: '?', Vars: []parser.Var{{ID: []string{"user", "first name"}}}},
+			"?first name=Gontrand", "?first%20name=Gontrand"},
+	} {
+		t.Run(tt.expr.String(), func(t *testing.T) {
+			ast := &parser.Ast{Parts: []interface{}{tt.expr}}
+			for _, opts := range []struct {
+				escape   bool
+				expected string
+			}{
+				{false, tt.raw},
+				{true, tt.expected},
+			} {
+				var out strings.Builder
+				ExecuteWith(ast, &out, data, Options{EscapeKeys: opts.escape})
+				if got := out.String(); got != opts.expected {
+					t.Errorf("EscapeKeys: %v, got:\n\t%q\nexpected:\n\t%q", opts.escape, got, opts.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestMaskFor(t *testing.T) {
+	data := map[string]interface{}{
+		"file": "lib+extra 1.tar.gz",
+		"list": []string{"a+b", "c d"},
+	}
+	opts := Options{MaskFor: func(op byte) (byte, bool) {
+		if op == '.' {
+			return escape.Disallowed, true
+		}
+		return 0, false
+	}}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"/dist{.file}", "/dist.lib+extra%201.tar.gz"},
+		{"/dist{.list*}", "/dist.a+b.c%20d"},
+		{"/dist/{file}", "/dist/lib%2Bextra%201.tar.gz"},
+		{"{/file}", "/lib%2Bextra%201.tar.gz"},
+		{"{?file}", "?file=lib%2Bextra%201.tar.gz"},
+		{"{+file}", "lib+extra%201.tar.gz"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			ExecuteWith(ast, &out, data, opts)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOmitZero(t *testing.T) {
+	zero := 0
+	type Params struct {
+		Page    int
+		Ratio   float64
+		Uint    uint8
+		Query   string
+		Active  bool
+		Since   time.Time
+		Limit   *int
+		Tags    []string
+		Created time.Time
+	}
+	data := Params{
+		Limit:   &zero,
+		Tags:    []string{"a"},
+		Created: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+	}
+	opts := Options{OmitZero: true}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"/search{?Page}", "/search"},
+		{"/search{?Ratio}", "/search"},
+		{"/search{?Uint}", "/search"},
+		{"/search{?Query}", "/search"},
+		{"/search{?Active}", "/search"},
+		{"/search{?Since}", "/search"},
+		{"/search{;Page}", "/search"},
+		{"/search{;Ratio}", "/search"},
+		{"/search{;Uint}", "/search"},
+		{"/search{;Query}", "/search"},
+		{"/search{;Active}", "/search"},
+		{"/search{;Since}", "/search"},
+		{"/search{?Limit}", "/search?Limit=0"},
+		{"/search{;Limit}", "/search;Limit=0"},
+		{"/search{?Page,Tags,Created}", "/search?Tags=a&Created=2021-03-04T00%3A00%3A00Z"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			ExecuteWith(ast, &out, data, opts)
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+	t.Run("map", func(t *testing.T) {
+		ast, _ := parser.Parse("{?q,page,lang}")
+		var out strings.Builder
+		ExecuteWith(ast, &out, map[string]string{"q": "", "page": "2", "lang": ""}, opts)
+		if got, expected := out.String(), "?page=2"; got != expected {
+			t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+		}
+	})
+}
+
+func TestEncodeLiterals(t *testing.T) {
+	data := map[string]string{"x": "é"}
+	for _, tt := range []struct {
+		template string
+		encode   bool
+		expected string
+	}{
+		{"/café/{x}", false, "/café/%C3%A9"},
+		{"/café/{x}", true, "/caf%C3%A9/%C3%A9"},
+		{"/日本/{x}", true, "/%E6%97%A5%E6%9C%AC/%C3%A9"},
+		{"/100%25/{x}", false, "/100%25/%C3%A9"},
+		{"/100%25/{x}", true, "/100%25/%C3%A9"},
+		{"/a%20b", true, "/a%20b"},
+		{"/search?q=1&lang=fr#top", true, "/search?q=1&lang=fr#top"},
+		{"/a;b=c,d@e!$()*+:", true, "/a;b=c,d@e!$()*+:"},
+		{"/a%2Fb", true, "/a%2Fb"},
+		{"/caf%C3%A9%2F", true, "/caf%C3%A9%2F"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, err := parser.Parse(tt.template)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			var out strings.Builder
+			ExecuteWith(ast, &out, data, Options{EncodeLiterals: tt.encode})
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDefaultsAndRequired(t *testing.T) {
+	opts := Options{
+		Defaults: map[string]interface{}{
+			"lang": "en",
+			"user": map[string]interface{}{"id": 1, "name": "guest"},
+			"tags": []string{"all"},
+		},
+		Required: []string{"id", "lang", "user.id"},
+	}
+	for _, tt := range []struct {
+		template string
+		data     interface{}
+		expected string
+		err      error
+	}{
+		{
+			"/users/{id}{?lang,tags*}",
+			map[string]string{"id": "42"},
+			"/users/42?lang=en&tags=all",
+			nil,
+		},
+		{
+			"/users/{id}{?lang}",
+			map[string]interface{}{"id": 42, "lang": "fr"},
+			"/users/42?lang=fr",
+			nil,
+		},
+		{
+			"/users/{user.id}/{user.name}",
+			map[string]interface{}{"id": 42, "user": map[string]interface{}{"name": "gontrand"}},
+			"/users/1/gontrand",
+			nil,
+		},
+		{
+			"/users/{user.id}",
+			map[string]interface{}{"id": 42, "user": map[string]interface{}{"id": 7}},
+			"/users/7",
+			nil,
+		},
+		{
+			"/users{?id}",
+			map[string]interface{}{"tags": []string{"a"}},
+			"",
+			RequiredError{Names: []string{"id"}},
+		},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteWith(ast, &out, tt.data, opts)
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+
+	ast, _ := parser.Parse("/search{?q,page,sort}")
+	opts = Options{Required: []string{"q", "page", "sort", "user.id"}}
+	data := map[string]interface{}{"page": 2, "sort": []string{}, "user": map[string]int{}}
+	err := ExecuteWith(ast, &strings.Builder{}, data, opts)
+	expected := RequiredError{Names: []string{"q", "sort", "user.id"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, expected)
+	}
+}
+
+func TestOnUndefined(t *testing.T) {
+	type call struct {
+		path []string
+		expr string
+	}
+	var calls []call
+	opts := Options{OnUndefined: func(path []string, expr *parser.Expr) (interface{}, bool) {
+		calls = append(calls, call{path, expr.String()})
+		if strings.Join(path, ".") == "user.lang" {
+			return []string{"en", "fr"}, true
+		}
+		return nil, false
+	}}
+	ast, _ := parser.Parse("/users/{id}{/missing}{?page,user.lang,tags*}")
+	data := map[string]interface{}{
+		"id":   42,
+		"user": map[string]string{"name": "gontrand"},
+		"tags": []string{},
+	}
+	var out strings.Builder
+	if err := ExecuteWith(ast, &out, data, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, expected := out.String(), "/users/42?lang=en,fr"; got != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+	expected := []call{
+		{[]string{"missing"}, "{/missing}"},
+		{[]string{"page"}, "{?page,user.lang,tags*}"},
+		{[]string{"user", "lang"}, "{?page,user.lang,tags*}"},
+		{[]string{"tags"}, "{?page,user.lang,tags*}"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("got calls:\n\t%q\nexpected:\n\t%q", calls, expected)
+	}
+}
+
+func TestFlatKeys(t *testing.T) {
+	type Nested struct {
+		DB map[string]string
+	}
+	for _, test := range []struct {
+		template string
+		data     interface{}
+		expected string
+	}{
+		{"{foo.bar}", map[string]string{"foo.bar": "x"}, "x"},
+		{"{?foo.bar,baz}", map[string]interface{}{"foo.bar": 1, "baz": 2}, "?bar=1&baz=2"},
+		{"{foo.bar}", map[string]interface{}{"foo": map[string]string{"bar": "nested"}, "foo.bar": "flat"}, "nested"},
+		{"{foo.bar.baz}", map[string]string{"foo.bar.baz": "x"}, "x"},
+		{"{DB.db.host}", Nested{map[string]string{"db.host": "localhost"}}, ""},
+		{"{foo.baz}", map[string]string{"foo.bar": "x"}, ""},
+	} {
+		ast, err := parser.Parse(test.template)
+		if err != nil {
+			t.Errorf("parse error: %v", err)
+			continue
+		}
+		var out strings.Builder
+		if err := ExecuteWith(ast, &out, test.data, Options{FlatKeys: true}); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.template, err)
+			continue
+		}
+		if got := out.String(); got != test.expected {
+			t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, test.expected)
+		}
+	}
+	ast, _ := parser.Parse("{foo.bar}")
+	if got, _ := ExecuteString(ast, map[string]string{"foo.bar": "x"}); got != "" {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, "")
+	}
+}
+
+func TestUndefinedPlaceholder(t *testing.T) {
+	data := map[string]interface{}{
+		"a":    "A",
+		"list": []string{},
+	}
+	for _, tt := range []struct {
+		template    string
+		placeholder string
+		expected    string
+	}{
+		{"{/a,b}", "none", "/A/none"},
+		{"{/b}{/list*}", "none", "/none/none"},
+		{"{b:2}{.b}{;b}", "none", "none.none;b=none"},
+		{"{+b}{#b}", "a b/c", "a%20b/c#a%20b/c"},
+		{"{/b}", "a b/c", "/a%20b%2Fc"},
+		{"{?a,b}{&b}", "none", "?a=A"},
+		{"{/a,b}", "", "/A"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			if err := ExecuteWith(ast, &out, data, Options{UndefinedPlaceholder: tt.placeholder}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDropEmptyQueryValues(t *testing.T) {
+	empty, q := "", "go"
+	data := map[string]interface{}{
+		"q":     "",
+		"ptr":   &empty,
+		"set":   &q,
+		"page":  0,
+		"list":  []string{""},
+		"keys":  map[string]string{"k": ""},
+		"slug":  Reserved(""),
+		"undef": nil,
+	}
+	for _, tt := range []struct {
+		template string
+		drop     string
+		spec     string
+	}{
+		{"{?q}", "", "?q="},
+		{"{?q,page}", "?page=0", "?q=&page=0"},
+		{"{?ptr,set}", "?set=go", "?ptr=&set=go"},
+		{"{&q}{&ptr}", "", "&q=&ptr="},
+		{"{;q,set}", ";set=go", ";q;set=go"},
+		{"{?slug,undef}", "", "?slug="},
+		{"{?list,keys*}", "?list=&k=", "?list=&k="},
+		{"{q}{+ptr}{#q}{.ptr}{/q}", "#./", "#./"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			for _, c := range []struct {
+				drop     bool
+				expected string
+			}{{true, tt.drop}, {false, tt.spec}} {
+				var out strings.Builder
+				if err := ExecuteWith(ast, &out, data, Options{DropEmptyQueryValues: c.drop}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := out.String(); got != c.expected {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, c.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteOptsDefaults(t *testing.T) {
+	for name, e := range loadFixture(t, "fixtures/extra-examples.json") {
+		for _, tt := range e.TestCases {
+			ast, err := parser.Parse(tt[0].(string))
+			if err != nil {
+				t.Errorf("%s: parse error: %v", name, err)
+				continue
+			}
+			var got, expected strings.Builder
+			gotErr := ExecuteOpts(ast, &got, e.Variables)
+			expectedErr := Execute(ast, &expected, e.Variables)
+			if got.String() != expected.String() || !reflect.DeepEqual(gotErr, expectedErr) {
+				t.Errorf("got:\n\t%q, %v\nexpected:\n\t%q, %v", got.String(), gotErr, expected.String(), expectedErr)
+			}
+		}
+	}
+}
+
+func TestExecuteOpts(t *testing.T) {
+	data := map[string]interface{}{
+		"id":   42,
+		"list": []string{"red", "green"},
+		"keys": map[string]string{"b": "2", "a": "1"},
+	}
+	upper := func(x interface{}) string { return strings.ToUpper(fmt.Sprint(x)) }
+	for _, tt := range []struct {
+		template string
+		opts     []Option
+		expected string
+		err      error
+	}{
+		{"/{id}{?keys*}", nil, "/42?a=1&b=2", nil},
+		{"/{id}{?keys*}", []Option{WithStrict(), WithMaxLen(100)}, "/42?a=1&b=2", nil},
+		{"/{id}{?keys*}", []Option{WithStrict(), WithMaxLen(4)}, "/42", TooLongError{Limit: 4, Part: 2}},
+		{"{list:2}", []Option{WithStrict(), WithMaxLen(100)}, "",
+			ModOnCompositeError{Path: []string{"list"}, Mod: parser.ModPrefix + 2}},
+		{"{list:2}", []Option{WithMaxLen(100)}, "red,green", nil},
+		{"{/list,page,lang}", []Option{
+			WithFormat("list", upper), WithDefault("page", 1), WithFormat("page", upper), WithDefault("lang", "fr"),
+		}, "/%5BRED%20GREEN%5D/1/fr", nil},
+		{"{/id,page}", []Option{WithRequired("id"), WithRequired("page", "lang"), WithDefault("lang", "fr")}, "",
+			RequiredError{Names: []string{"page"}}},
+		{"{/id}{/page}", []Option{WithPartial(), WithRequirePathVars()}, "/42{/page}", nil},
+		{"{/id}{/page}", []Option{WithRequirePathVars(), WithPartial()}, "/42{/page}", nil},
+		{"{id}{.page}", []Option{WithRequirePathVars(), WithMaxLen(0)}, "42", ResolveError{Path: []string{"page"}}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			err := ExecuteOpts(ast, &out, data, tt.opts...)
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%#v\nexpected:\n\t%#v", err, tt.err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
@@ -10,48 +10,716 @@
 package execute
 
 import (
+	"database/sql/driver"
+	"encoding"
+	"errors"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/aksamyt/uritemplate/pkg/parser"
 )
 
-func dereference(v *reflect.Value) {
+// dereference follows the interfaces and pointers holding v, and reports
+// whether there was a pointer among them.
+func dereference(v *reflect.Value) (pointer bool) {
 	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		pointer = pointer || v.Kind() == reflect.Ptr
 		*v = v.Elem()
 	}
+	return
 }
 
-// getByTag assumes s is a Struct value.
-func getByTag(s reflect.Value, key string) reflect.Value {
-	for i, t := 0, s.Type(); i < t.NumField(); i++ {
-		field := t.Field(i)
-		if tag, ok := field.Tag.Lookup("uri"); ok && tag == key {
-			return s.Field(i)
+// fieldByIndex is like reflect.Value.FieldByIndex, but returns an invalid
+// value instead of panicking on nil embedded pointers.
+func fieldByIndex(s reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && s.Kind() == reflect.Ptr {
+			if s.IsNil() {
+				return reflect.Value{}
+			}
+			s = s.Elem()
 		}
+		s = s.Field(x)
 	}
-	return reflect.Value{}
+	return s
 }
 
+// getByKey returns the value for key in data, after following the pointers
+// and interfaces holding data. The value is returned as found, and may be
+// a pointer or an interface itself.
 func getByKey(data reflect.Value, key string) (value reflect.Value) {
+	dereference(&data)
+	if m, ok := orderedMap(data); ok {
+		return reflect.ValueOf(m.Get(key))
+	}
 	switch data.Kind() {
 	case reflect.Map:
-		keyValue := reflect.ValueOf(key)
-		if keyValue.Type().AssignableTo(data.Type().Key()) {
+		if keyValue, err := mapKey(data.Type().Key(), key); err == nil {
 			value = data.MapIndex(keyValue)
 		}
+		if !value.IsValid() && data.Type().Key().Kind() == reflect.Interface {
+			value = mapIndexScalar(data, key)
+		}
 	case reflect.Struct:
-		if value = data.FieldByName(key); !value.IsValid() {
-			value = getByTag(data, key)
+		if index := fieldIndex(data.Type(), key); index != nil {
+			value = fieldByIndex(data, index)
+		}
+	case reflect.Slice, reflect.Array:
+		if i, ok := sliceIndex(data, key); ok && i < data.Len() {
+			value = data.Index(i)
 		}
 	}
-	dereference(&value)
 	return
 }
 
-func findVariableValue(data reflect.Value, v *parser.Var) reflect.Value {
-	value := data
-	for _, part := range v.ID {
+// mapIndexScalar looks key up in a map with interface keys, like the maps
+// decoded from YAML, whose keys that read as numbers or booleans are not
+// strings: it tries the integers and the boolean that key reads as.
+func mapIndexScalar(data reflect.Value, key string) reflect.Value {
+	var candidates [3]interface{}
+	keys := candidates[:0]
+	if i, err := strconv.ParseInt(key, 10, 64); err == nil {
+		if int64(int(i)) == i {
+			keys = append(keys, int(i))
+		}
+		keys = append(keys, i)
+	} else if u, err := strconv.ParseUint(key, 10, 64); err == nil {
+		keys = append(keys, u)
+	} else if key == "true" || key == "false" {
+		keys = append(keys, key == "true")
+	}
+	t := data.Type().Key()
+	for _, k := range keys {
+		if kv := reflect.ValueOf(k); kv.Type().Implements(t) {
+			if value := data.MapIndex(kv); value.IsValid() {
+				return value
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
+// sliceIndex parses key as an index of the list value data, in base 10. It
+// fails for keys that are not non-negative integers, and for bytes, which
+// are not a list. The index may be out of range.
+func sliceIndex(data reflect.Value, key string) (int, bool) {
+	if isBytes(data) {
+		return 0, false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '0' || key[i] > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.Atoi(key)
+	return i, err == nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// errUnsupportedKey is the error of mapKey for the key types that no key
+// converts to.
+var errUnsupportedKey = errors.New("unsupported key type")
+
+// mapKey converts key, a part of a variable name, to a key of the map key
+// type t. Keys implementing encoding.TextUnmarshaler are decoded, string and
+// interface keys are looked up as is, and integer keys are parsed in base 10.
+// It fails for other key types, and for keys that do not convert, which then
+// resolve to undefined, or fail the expansion in strict mode. See
+// mapIndexScalar for the interface keys that are not strings.
+func mapKey(t reflect.Type, key string) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		k := reflect.New(t)
+		if err := k.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, err
+		}
+		return k.Elem(), nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(key).Convert(t), nil
+	case reflect.Interface:
+		if stringType.Implements(t) {
+			return reflect.ValueOf(key), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		k := reflect.New(t).Elem()
+		k.SetInt(i)
+		return k, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		k := reflect.New(t).Elem()
+		k.SetUint(u)
+		return k, nil
+	}
+	return reflect.Value{}, errUnsupportedKey
+}
+
+func findPath(value reflect.Value, path []string) reflect.Value {
+	for _, part := range path {
 		value = getByKey(value, part)
 	}
 	return value
 }
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// getter calls the getter method of a struct value for key, if it has one:
+// a niladic exported method named key, or Get followed by key with its first
+// letter in upper case, that returns a single value, optionally followed by
+// an error. The method set of the pointer to the struct is searched, so that
+// both value and pointer receivers are found; unaddressable structs are
+// copied for that.
+func getter(data reflect.Value, key string) (reflect.Value, error) {
+	if data.Kind() != reflect.Struct || !data.CanInterface() {
+		return reflect.Value{}, nil
+	}
+	ptr := data
+	if data.CanAddr() {
+		ptr = data.Addr()
+	} else {
+		ptr = reflect.New(data.Type())
+		ptr.Elem().Set(data)
+	}
+	method := ptr.MethodByName(key)
+	if !method.IsValid() {
+		r, size := utf8.DecodeRuneInString(key)
+		method = ptr.MethodByName("Get" + string(unicode.ToUpper(r)) + key[size:])
+	}
+	if !method.IsValid() {
+		return reflect.Value{}, nil
+	}
+	t := method.Type()
+	if t.NumIn() != 0 || t.NumOut() == 0 || t.NumOut() > 2 ||
+		t.NumOut() == 2 && t.Out(1) != errorType {
+		return reflect.Value{}, nil
+	}
+	out := method.Call(nil)
+	if len(out) == 2 && !out[1].IsNil() {
+		return reflect.Value{}, out[1].Interface().(error)
+	}
+	return out[0], nil
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// driverValue replaces value by the result of its Value method if it
+// implements driver.Valuer, like the nullable types of database/sql, unless
+// it has a text of its own. A nil result is undefined, and so is the value
+// if Value returns an error, which fails the expansion in strict mode. The
+// method set of the pointer to an addressable value is checked too.
+func (e *exprWriter) driverValue(v *parser.Var, value reflect.Value) (reflect.Value, bool) {
+	if !value.IsValid() {
+		return value, true
+	}
+	if value.CanAddr() && value.Addr().Type().Implements(valuerType) {
+		value = value.Addr()
+	} else if !value.Type().Implements(valuerType) {
+		return value, true
+	}
+	if _, ok := text(value); ok || !value.CanInterface() {
+		dereference(&value)
+		return value, true
+	}
+	x, err := value.Interface().(driver.Valuer).Value()
+	if err != nil {
+		if e.opts.Strict {
+			e.fail(ValuerError{Path: v.ID, Err: err})
+		}
+		return reflect.Value{}, false
+	}
+	value = reflect.ValueOf(x)
+	dereference(&value)
+	return value, value.IsValid()
+}
+
+// findPath is like the function findPath, but follows v.ID from its part
+// from only, and falls back to the getter methods of structs with
+// Options.AllowMethods. The error of a getter makes the variable undefined,
+// and so do an index out of the range of a list and a map key that does not
+// convert; those fail the expansion in strict mode.
+func (e *exprWriter) findPath(value reflect.Value, v *parser.Var, from int) reflect.Value {
+	if !e.opts.AllowMethods && !e.opts.Strict {
+		return findPath(value, v.ID[from:])
+	}
+	for i := from; i < len(v.ID); i++ {
+		dereference(&value)
+		next := getByKey(value, v.ID[i])
+		elem := next
+		dereference(&elem)
+		if elem.IsValid() {
+			value = next
+			continue
+		}
+		if e.opts.Strict && (value.Kind() == reflect.Slice || value.Kind() == reflect.Array) {
+			if index, ok := sliceIndex(value, v.ID[i]); ok && index >= value.Len() {
+				e.fail(IndexError{Path: v.ID[:i+1], Len: value.Len()})
+				return reflect.Value{}
+			}
+		}
+		if e.opts.Strict && value.Kind() == reflect.Map && value.Type().Key().Kind() != reflect.Interface {
+			if _, ok := orderedMap(value); !ok {
+				if _, err := mapKey(value.Type().Key(), v.ID[i]); err != nil {
+					e.fail(KeyConversionError{Path: v.ID[:i+1], Type: value.Type().Key(), Err: err})
+					return reflect.Value{}
+				}
+			}
+		}
+		if !e.opts.AllowMethods {
+			return reflect.Value{}
+		}
+		var err error
+		if value, err = getter(value, v.ID[i]); err != nil {
+			if e.opts.Strict {
+				e.fail(MethodError{Path: v.ID[:i+1], Err: err})
+			}
+			return reflect.Value{}
+		}
+	}
+	return value
+}
+
+// lookupString is the fast path for the common maps of strings: it finds
+// plain string values without going through reflection.
+func (e *exprWriter) lookupString(v *parser.Var) (string, bool) {
+	if len(v.ID) != 1 {
+		return "", false
+	}
+	switch {
+	case e.strs != nil:
+		s, ok := e.strs[v.ID[0]]
+		return s, ok
+	case e.ifaces != nil:
+		s, ok := e.ifaces[v.ID[0]].(string)
+		return s, ok
+	}
+	return "", false
+}
+
+// lookup finds the value of a variable. The head of the variable is looked
+// up without reflection when the data is one of the common maps, and without
+// searching when its field index was compiled.
+//
+// With Options.FlatKeys, a qualified variable that is not found that way is
+// looked up again with its dotted name as the key of the data.
+//
+// found is only false for a variable that the data’s Resolver reported as
+// undefined; other variables are defined if their value is valid. With
+// Options.OmitZero, scalars holding their zero value are undefined too,
+// unless they were reached through a pointer.
+func (e *exprWriter) lookup(v *parser.Var) (value reflect.Value, found bool) {
+	switch {
+	case e.resolver != nil:
+		x, ok := e.resolver.ResolveURIVar(v.ID)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		value, found = reflect.ValueOf(x), true
+	case e.field != nil && e.data.IsValid() && e.data.Type() == e.ftype:
+		value = fieldByIndex(e.data, e.field)
+		value = e.findPath(value, v, 1)
+	case e.strs != nil:
+		// lookupString already found every value there is
+	case e.ifaces != nil:
+		head, ok := e.ifaces[v.ID[0]]
+		if !ok {
+			break
+		}
+		value = reflect.ValueOf(head)
+		value = e.findPath(value, v, 1)
+	default:
+		value = e.findPath(e.data, v, 0)
+	}
+	if e.opts.FlatKeys && len(v.ID) > 1 && !value.IsValid() && e.err == nil {
+		value = getByKey(e.data, strings.Join(v.ID, "."))
+	}
+	pointer := dereference(&value)
+	if e.opts.OmitZero && !pointer && isZero(value) {
+		return reflect.Value{}, false
+	}
+	return value, found || value.IsValid()
+}
+
+// resolve finds the value of a variable, in the data or in Options.Defaults,
+// and replaces it by its driver.Valuer value unless it has a format. When
+// the expansion is part of a Set, the values are resolved once for all its
+// templates, and the scalars are kept rendered, as strings.
+func (e *exprWriter) resolve(v *parser.Var, format func(interface{}) string) (value reflect.Value, found bool) {
+	var key string
+	if e.resolved != nil {
+		key = v.ID[0]
+		if len(v.ID) > 1 {
+			key = strings.Join(v.ID, ".")
+		}
+		if r, ok := e.resolved[key]; ok {
+			return r.value, r.found
+		}
+	}
+	value, found = e.lookup(v)
+	if !found && e.opts.Defaults != nil {
+		value, found = e.defaultValue(v)
+	}
+	if format == nil && found {
+		value, found = e.driverValue(v, value)
+	}
+	if e.resolved != nil && e.err == nil {
+		if found && value.IsValid() && value.Type() != stringType && value.Type() != reservedType &&
+			!isList(value) && !isAssociative(value) && !isOpaque(value) && !isBytes(value) {
+			value = reflect.ValueOf(stringify(value))
+		}
+		e.resolved[key] = resolved{value, found}
+	}
+	return
+}
+
+// defaultValue finds the value of a variable in Options.Defaults.
+func (e *exprWriter) defaultValue(v *parser.Var) (reflect.Value, bool) {
+	x, ok := e.opts.Defaults[v.ID[0]]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	value := e.findPath(reflect.ValueOf(x), v, 1)
+	dereference(&value)
+	return value, value.IsValid()
+}
+
+// isUndefined reports whether a variable with value is undefined: when it
+// was not found, and when its value is an empty list or associative array,
+// or has no text, like functions.
+func isUndefined(value reflect.Value, found bool) bool {
+	switch {
+	case !found, isOpaque(value),
+		isList(value) && len(listItems(value)) == 0,
+		isAssociative(value) && len(associativePairs(value)) == 0:
+		return true
+	}
+	return false
+}
+
+// checkRequired fails with a RequiredError if variables of
+// Options.Required are undefined.
+func (e *exprWriter) checkRequired() error {
+	var missing []string
+	for _, name := range e.opts.Required {
+		v := parser.Var{ID: strings.Split(name, ".")}
+		if s, ok := e.lookupString(&v); ok && !(e.opts.OmitZero && s == "") {
+			continue
+		}
+		value, found := e.lookup(&v)
+		if !found && e.opts.Defaults != nil {
+			value, found = e.defaultValue(&v)
+		}
+		if found {
+			value, found = e.driverValue(&v, value)
+		}
+		if e.err != nil {
+			return e.err
+		}
+		if isUndefined(value, found) {
+			missing = append(missing, name)
+		}
+	}
+	if missing != nil {
+		return RequiredError{Names: missing}
+	}
+	return nil
+}
+
+// pair is a key and its value, as found in an associative value.
+type pair struct {
+	key   string
+	value reflect.Value
+}
+
+// OrderedMap is implemented by associative values that keep their keys in
+// a meaningful order, such as insertion order. They are expanded in the order
+// given by Keys, instead of sorted like maps.
+type OrderedMap interface {
+	Keys() []string
+	Get(key string) interface{}
+}
+
+// Ranger is implemented by associative values that enumerate their pairs
+// without being maps, such as the rows of a cursor. They are expanded in the
+// order Range gives them, with their keys rendered like scalars. Range stops
+// when f returns false.
+type Ranger interface {
+	Range(f func(k, v interface{}) bool)
+}
+
+// Lister is implemented by list values that give their items one by one
+// without being slices, such as the IDs read from a cursor, so that they do
+// not need to be copied into a slice to be expanded. Values implementing
+// OrderedMap or Ranger are associative arrays instead.
+type Lister interface {
+	Len() int
+	Index(i int) interface{}
+}
+
+// enumeration is how the members of a list or associative value are found:
+// through reflection, or through one of the interfaces they implement.
+type enumeration uint8
+
+const (
+	byReflection enumeration = iota
+	byOrderedMap
+	byRanger
+	byLister
+)
+
+// enumerations are the types of enumerating interfaces, by precedence: a
+// type implementing several of them uses the first.
+var enumerations = []struct {
+	t reflect.Type
+	e enumeration
+}{
+	{reflect.TypeOf((*OrderedMap)(nil)).Elem(), byOrderedMap},
+	{reflect.TypeOf((*Ranger)(nil)).Elem(), byRanger},
+	{reflect.TypeOf((*Lister)(nil)).Elem(), byLister},
+}
+
+// enumerationCache maps types to the [2]enumeration of their values and of
+// pointers to them.
+var enumerationCache sync.Map
+
+// enumerationsOf returns the enumerations of the values of type t, and of
+// pointers to them.
+func enumerationsOf(t reflect.Type) (value, pointer enumeration) {
+	if e, ok := enumerationCache.Load(t); ok {
+		e := e.([2]enumeration)
+		return e[0], e[1]
+	}
+	var e [2]enumeration
+	for i, t := range []reflect.Type{t, reflect.PtrTo(t)} {
+		for _, x := range enumerations {
+			if t.Implements(x.t) {
+				e[i] = x.e
+				break
+			}
+		}
+	}
+	enumerationCache.Store(t, e)
+	return e[0], e[1]
+}
+
+// enumerator returns value as the interface its members are enumerated
+// through, if any. Like for text, the method set of the pointer to an
+// addressable value is checked too.
+func enumerator(value reflect.Value) (interface{}, enumeration) {
+	if !value.IsValid() {
+		return nil, byReflection
+	}
+	// checking the types first spares boxing every value into an interface
+	e, pointer := enumerationsOf(value.Type())
+	if pointer != byReflection && value.CanAddr() && value.Addr().CanInterface() {
+		return value.Addr().Interface(), pointer
+	}
+	if !value.CanInterface() {
+		return nil, byReflection
+	}
+	if value.Kind() == reflect.Interface {
+		x := value.Interface()
+		if x == nil {
+			return nil, byReflection
+		}
+		e, _ = enumerationsOf(reflect.TypeOf(x))
+		return x, e
+	}
+	if e != byReflection {
+		return value.Interface(), e
+	}
+	return nil, byReflection
+}
+
+// orderedMap returns value as an OrderedMap, if it implements it.
+func orderedMap(value reflect.Value) (OrderedMap, bool) {
+	if x, e := enumerator(value); e == byOrderedMap {
+		return x.(OrderedMap), true
+	}
+	return nil, false
+}
+
+// ranger returns value as a Ranger, if it implements it and not OrderedMap.
+func ranger(value reflect.Value) (Ranger, bool) {
+	if x, e := enumerator(value); e == byRanger {
+		return x.(Ranger), true
+	}
+	return nil, false
+}
+
+// lister returns value as a Lister, if it implements it and is not an
+// associative array.
+func lister(value reflect.Value) (Lister, bool) {
+	if x, e := enumerator(value); e == byLister {
+		return x.(Lister), true
+	}
+	return nil, false
+}
+
+// isList reports whether value must be expanded as a list. Bytes are not
+// a list, but a scalar.
+func isList(value reflect.Value) bool {
+	switch _, e := enumerator(value); e {
+	case byLister:
+		return true
+	case byOrderedMap, byRanger:
+		return false
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return !isBytes(value)
+	}
+	return false
+}
+
+// isOpaque reports whether value has no meaningful text, like channels and
+// functions, whose default rendering would leak a memory address into the
+// output. They are treated as undefined, unless they implement one of the
+// interfaces of text.
+func isOpaque(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		_, ok := text(value)
+		return !ok
+	}
+	return false
+}
+
+// isZero reports whether value is a scalar holding its zero value, as told
+// by its IsZero method if it has one, like time.Time. Lists and associative
+// arrays are never zero: they are undefined when they are empty.
+func isZero(value reflect.Value) bool {
+	if !value.IsValid() || isList(value) || isAssociative(value) {
+		return false
+	}
+	if value.CanInterface() {
+		if z, ok := value.Interface().(interface{ IsZero() bool }); ok {
+			return z.IsZero()
+		}
+	}
+	return value.IsZero()
+}
+
+// listItems returns the defined items of a list value.
+func listItems(value reflect.Value) (items []reflect.Value) {
+	if l, ok := lister(value); ok {
+		return listerItems(l)
+	}
+	for i := 0; i < value.Len(); i++ {
+		item := value.Index(i)
+		dereference(&item)
+		if item.IsValid() && !isOpaque(item) {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// listerItems returns the defined items of l.
+func listerItems(l Lister) (items []reflect.Value) {
+	for i, n := 0, l.Len(); i < n; i++ {
+		item := reflect.ValueOf(l.Index(i))
+		dereference(&item)
+		if item.IsValid() && !isOpaque(item) {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// isAssociative reports whether value must be expanded as an associative
+// array. Structs that know how to render themselves as text are scalars.
+func isAssociative(value reflect.Value) bool {
+	switch _, e := enumerator(value); e {
+	case byOrderedMap, byRanger:
+		return true
+	case byLister:
+		return false
+	}
+	switch value.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Struct:
+		_, ok := text(value)
+		return !ok
+	}
+	return false
+}
+
+// hasAssociative reports whether one of the items of a list is an
+// associative array.
+func hasAssociative(items []reflect.Value) bool {
+	for _, item := range items {
+		if isAssociative(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// associativePairs lists the defined pairs of a map or struct value in
+// expansion order: ordered maps follow their keys, Rangers their own order,
+// maps are sorted by key, and structs follow the declaration order of their
+// exported fields, named by their "uri" tag if they have one, and leaving
+// out the fields tagged "-".
+func associativePairs(value reflect.Value) (pairs []pair) {
+	if m, ok := orderedMap(value); ok {
+		for _, key := range m.Keys() {
+			elem := reflect.ValueOf(m.Get(key))
+			dereference(&elem)
+			if elem.IsValid() && !isOpaque(elem) {
+				pairs = append(pairs, pair{key, elem})
+			}
+		}
+		return
+	}
+	if r, ok := ranger(value); ok {
+		return rangePairs(r)
+	}
+	if value.Kind() == reflect.Map {
+		keys, rendered := sortedMapKeys(value)
+		for i, key := range keys {
+			elem := value.MapIndex(key)
+			dereference(&elem)
+			if elem.IsValid() && !isOpaque(elem) {
+				pairs = append(pairs, pair{rendered[i], elem})
+			}
+		}
+		return
+	}
+	for _, field := range indexOf(value.Type()).fields {
+		elem := value.Field(field.index)
+		dereference(&elem)
+		if elem.IsValid() && !isOpaque(elem) {
+			pairs = append(pairs, pair{field.key, elem})
+		}
+	}
+	return
+}
+
+// rangePairs lists the defined pairs of r, in the order Range gives them.
+// It is apart from associativePairs, so that the closure does not move the
+// pairs of every other associative array to the heap.
+func rangePairs(r Ranger) (pairs []pair) {
+	r.Range(func(k, v interface{}) bool {
+		key, elem := reflect.ValueOf(k), reflect.ValueOf(v)
+		dereference(&key)
+		dereference(&elem)
+		if key.IsValid() && elem.IsValid() && !isOpaque(elem) {
+			pairs = append(pairs, pair{stringify(key), elem})
+		}
+		return true
+	})
+	return
+}
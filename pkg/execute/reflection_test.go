@@ -2,6 +2,11 @@ package execute
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/aksamyt/uritemplate/pkg/parser"
@@ -15,6 +20,10 @@ type Name struct{}
 
 func (Name) String() string { return "Gontrand" }
 
+type PtrID struct{}
+
+func (*PtrID) String() string { return "270319070" }
+
 func TestReflection(t *testing.T) {
 	ast, _ := parser.Parse("/hello{/id,Name}")
 	expected := "/hello/270319070/Gontrand"
@@ -71,6 +80,141 @@ func TestReflection(t *testing.T) {
 	}
 }
 
+func TestPointerReceivers(t *testing.T) {
+	ast, _ := parser.Parse("/hello{/id,Name}")
+
+	for _, tt := range []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"addressable struct field",
+			&struct {
+				ID   PtrID `uri:"id"`
+				Name string
+			}{Name: "Gontrand"},
+			"/hello/270319070/Gontrand",
+		},
+		{"slice element",
+			map[string]interface{}{
+				"id":   []PtrID{{}},
+				"Name": "Gontrand",
+			},
+			"/hello/270319070/Gontrand",
+		},
+		{"non-addressable map value",
+			map[string]PtrID{"id": {}},
+			"/hello",
+		},
+		{"non-addressable struct field",
+			struct {
+				ID   PtrID `uri:"id"`
+				Name string
+			}{Name: "Gontrand"},
+			"/hello/Gontrand",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			Execute(ast, &buf, tt.data)
+			got := buf.String()
+			if got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type Address struct {
+	City    string `uri:"city"`
+	State   string `uri:"state"`
+	Country string
+	private string
+}
+
+func TestStructComposites(t *testing.T) {
+	asStruct := map[string]interface{}{
+		"address": Address{City: "Paris", State: "IDF", Country: "France"},
+		"person": struct {
+			Address *Address `uri:"address"`
+		}{&Address{City: "Paris", State: "IDF", Country: "France"}},
+	}
+	asMap := map[string]interface{}{
+		"address": map[string]string{
+			"city":    "Paris",
+			"state":   "IDF",
+			"Country": "France",
+		},
+		"person": map[string]interface{}{
+			"address": map[string]string{
+				"city":    "Paris",
+				"state":   "IDF",
+				"Country": "France",
+			},
+		},
+	}
+	for _, tt := range []struct {
+		template   string
+		structWant string
+		mapWant    string
+	}{
+		{
+			"{address}",
+			"city,Paris,state,IDF,Country,France",
+			"Country,France,city,Paris,state,IDF",
+		},
+		{
+			"{address*}",
+			"city=Paris,state=IDF,Country=France",
+			"Country=France,city=Paris,state=IDF",
+		},
+		{
+			"{/address*}",
+			"/city=Paris/state=IDF/Country=France",
+			"/Country=France/city=Paris/state=IDF",
+		},
+		{
+			"{;address*}",
+			";city=Paris;state=IDF;Country=France",
+			";Country=France;city=Paris;state=IDF",
+		},
+		{
+			"{?address}",
+			"?address=city,Paris,state,IDF,Country,France",
+			"?address=Country,France,city,Paris,state,IDF",
+		},
+		{
+			"{?address*}",
+			"?city=Paris&state=IDF&Country=France",
+			"?Country=France&city=Paris&state=IDF",
+		},
+		{
+			"{?person.address*}",
+			"?city=Paris&state=IDF&Country=France",
+			"?Country=France&city=Paris&state=IDF",
+		},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			for _, data := range []struct {
+				name     string
+				data     interface{}
+				expected string
+			}{
+				{"struct", asStruct, tt.structWant},
+				{"map", asMap, tt.mapWant},
+			} {
+				var buf bytes.Buffer
+				Execute(ast, &buf, data.data)
+				got := buf.String()
+				if got != data.expected {
+					t.Errorf("%s: got:\n\t%q\nexpected:\n\t%q", data.name, got, data.expected)
+				}
+			}
+		})
+	}
+}
+
 func TestNesting(t *testing.T) {
 	ast, _ := parser.Parse("/hello{?person.firstName,person.lastName}")
 	expected := "/hello?firstName=Gontrand&lastName=Fauxfilet"
@@ -109,6 +253,97 @@ func TestNesting(t *testing.T) {
 	}
 }
 
+func TestNestedComposites(t *testing.T) {
+	data := map[string]interface{}{
+		"filters": map[string][]string{"tag": {"a", "b"}, "lang": {"go"}, "none": {}},
+		"rows":    []map[string]string{{"x": "1"}, {"y": "2"}},
+		"deep": map[string]interface{}{
+			"m": []interface{}{"a", []string{"b", "c"}, map[string]int{"d": 4}},
+		},
+		"matrix": [][]int{{1, 2}, {3}},
+	}
+	for _, tt := range []struct {
+		template string
+		strict   bool
+		expected string
+		err      error
+	}{
+		{"{?filters*}", false, "?lang=go&tag=a&tag=b", nil},
+		{"{;filters*}", false, ";lang=go;tag=a;tag=b", nil},
+		{"{filters*}", false, "lang=go,tag=a,tag=b", nil},
+		{"{?filters}", false, "?filters=lang,go,none,,tag,a,b", nil},
+		{"{filters}", false, "lang,go,none,,tag,a,b", nil},
+		{"{?rows}", false, "?rows=x,1,y,2", nil},
+		{"{rows}", false, "x,1,y,2", nil},
+		{"{?rows*}", false, "?x=1&y=2", nil},
+		{"{?rows*}", true, "?x=1&y=2", nil},
+		{"{rows*}", true, "x=1,y=2", nil},
+		{"{?rows}", true, "", NestedCompositeError{Path: []string{"rows"}}},
+		{"{?deep*}", false, "?m=a&m=b,c&m=d,4", nil},
+		{"{;deep}", false, ";deep=m,a,b,c,d,4", nil},
+		{"{deep}", false, "m,a,b,c,d,4", nil},
+		{"{?matrix*}", true, "?matrix=1,2&matrix=3", nil},
+		{"{/matrix*}", false, "/1,2/3", nil},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			err := ExecuteWith(ast, &buf, data, Options{Strict: tt.strict})
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type Item struct {
+	Name  string `uri:"name"`
+	Value string `uri:"value"`
+}
+
+func TestStructsExplode(t *testing.T) {
+	data := map[string]interface{}{
+		"items": []Item{{"a", "1"}, {"b", "2"}},
+		"ptrs":  []*Item{{"c", "3"}, nil},
+		"mixed": []interface{}{"x", Item{"d", "4"}, map[string]string{"k": "v"}},
+	}
+	for _, tt := range []struct {
+		template string
+		strict   bool
+		expected string
+		err      error
+	}{
+		{"{?items*}", false, "?name=a&value=1&name=b&value=2", nil},
+		{"{?items*}", true, "?name=a&value=1&name=b&value=2", nil},
+		{"{;items*}", false, ";name=a;value=1;name=b;value=2", nil},
+		{"{/items*}", false, "/name=a/value=1/name=b/value=2", nil},
+		{"{&items*}", false, "&name=a&value=1&name=b&value=2", nil},
+		{"{&items*}", true, "&name=a&value=1&name=b&value=2", nil},
+		{"{?items}", false, "?items=name,a,value,1,name,b,value,2", nil},
+		{"{?items}", true, "", NestedCompositeError{Path: []string{"items"}}},
+		{"{items}", true, "", NestedCompositeError{Path: []string{"items"}}},
+		{"{?ptrs*}", false, "?name=c&value=3", nil},
+		{"{?mixed*}", false, "?mixed=x&name=d&value=4&k=v", nil},
+		{"{?mixed*}", true, "?mixed=x&name=d&value=4&k=v", nil},
+		{"{?mixed}", true, "", NestedCompositeError{Path: []string{"mixed"}}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			err := ExecuteWith(ast, &buf, data, Options{Strict: tt.strict})
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestFailSilently(t *testing.T) {
 	ast, _ := parser.Parse("/hello/{name}")
 	expected := "/hello/"
@@ -119,3 +354,464 @@ func TestFailSilently(t *testing.T) {
 		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
 	}
 }
+
+type insertionMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *insertionMap) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *insertionMap) Keys() []string             { return m.keys }
+func (m *insertionMap) Get(key string) interface{} { return m.values[key] }
+
+func TestOrderedMap(t *testing.T) {
+	m := &insertionMap{}
+	m.Set("zeta", "last")
+	m.Set("alpha", "first")
+	m.Set("undef", nil)
+	m.Set("mid", 42)
+	data := map[string]interface{}{"m": m}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{?m*}", "?zeta=last&alpha=first&mid=42"},
+		{"{?m}", "?m=zeta,last,alpha,first,mid,42"},
+		{"{/m*}", "/zeta=last/alpha=first/mid=42"},
+		{"{m.alpha}{?m.mid}", "first?mid=42"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			Execute(ast, &buf, data)
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// generator lists n items made on demand by f.
+type generator struct {
+	n int
+	f func(i int) interface{}
+}
+
+func (g generator) Len() int                { return g.n }
+func (g generator) Index(i int) interface{} { return g.f(i) }
+
+// pairGenerator ranges over n pairs made on demand by f.
+type pairGenerator struct {
+	n int
+	f func(i int) (k, v interface{})
+}
+
+func (g pairGenerator) Range(f func(k, v interface{}) bool) {
+	for i := 0; i < g.n && f(g.f(i)); i++ {
+	}
+}
+
+func TestListerRanger(t *testing.T) {
+	id := func(i int) interface{} {
+		if i == 2 {
+			return nil
+		}
+		return 100 + 7*i
+	}
+	pair := func(i int) (interface{}, interface{}) {
+		return string(rune('a' + i)), id(i)
+	}
+	generated := map[string]interface{}{
+		"ids":   generator{4, id},
+		"kv":    pairGenerator{4, pair},
+		"none":  generator{0, id},
+		"empty": pairGenerator{0, pair},
+		"num":   pairGenerator{2, func(i int) (interface{}, interface{}) { return i, "x y" }},
+	}
+	materialized := map[string]interface{}{
+		"ids":   []interface{}{100, 107, nil, 121},
+		"kv":    map[string]interface{}{"a": 100, "b": 107, "c": nil, "d": 121},
+		"none":  []int{},
+		"empty": map[string]int{},
+		"num":   map[int]string{0: "x y", 1: "x y"},
+	}
+	for _, template := range []string{
+		"{ids}", "{ids*}", "{+ids}", "{#ids*}", "{.ids}", "{/ids*}",
+		"{;ids}", "{;ids*}", "{?ids}", "{?ids*}", "{&ids*}",
+		"{kv}", "{kv*}", "{+kv*}", "{.kv}", "{/kv*}",
+		"{;kv*}", "{?kv}", "{?kv*}", "{&kv}",
+		"{?none,empty}", "{;none*,empty*}", "{num*}", "{?num}",
+	} {
+		t.Run(template, func(t *testing.T) {
+			ast, _ := parser.Parse(template)
+			got, _ := ExecuteString(ast, generated)
+			expected, _ := ExecuteString(ast, materialized)
+			if got != expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+			}
+		})
+	}
+}
+
+type Tagged struct {
+	ID   string `uri:"id"`
+	Kind string `uri:"kind"`
+}
+
+type OtherTagged struct {
+	Ref  string `uri:"id"`
+	Kind string `uri:"kind"`
+}
+
+type DeepTagged struct {
+	Tagged
+}
+
+func TestEmbeddedTags(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"embedded", struct{ Tagged }{Tagged{ID: "42", Kind: "post"}}, "/42/post"},
+		{"embedded pointer", struct{ *Tagged }{&Tagged{ID: "42", Kind: "post"}}, "/42/post"},
+		{"nil embedded pointer", struct{ *Tagged }{}, "/"},
+		{"deeper", struct{ Inner struct{ Tagged } }{}, "/"},
+		{"shadowed", struct {
+			Tagged
+			Own string `uri:"id"`
+		}{Tagged{ID: "42", Kind: "post"}, "7"}, "/7/post"},
+		{"shadowed by depth", struct {
+			DeepTagged
+			OtherTagged
+		}{DeepTagged{Tagged{ID: "42"}}, OtherTagged{Ref: "7", Kind: "page"}}, "/7/page"},
+		{"conflict", struct {
+			Tagged
+			OtherTagged
+		}{Tagged{ID: "42", Kind: "post"}, OtherTagged{Ref: "7", Kind: "page"}}, "/"},
+		{"tagged embedded", struct {
+			Tagged `uri:"tagged"`
+		}{Tagged{ID: "42", Kind: "post"}}, "/"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, _ := parser.Parse("/{id}{/kind}")
+			var buf bytes.Buffer
+			Execute(ast, &buf, tt.data)
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOpaqueValues(t *testing.T) {
+	data := struct {
+		Ch   chan int               `uri:"ch"`
+		Fn   func()                 `uri:"fn"`
+		List []interface{}          `uri:"list"`
+		Map  map[string]interface{} `uri:"map"`
+		ID   ID                     `uri:"id"`
+	}{
+		Ch:   make(chan int),
+		Fn:   func() {},
+		List: []interface{}{"a", make(chan int), func() {}},
+		Map:  map[string]interface{}{"a": "b", "ch": make(chan int)},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"/{ch}", "/"},
+		{"/{fn}{?ch,fn}", "/"},
+		{"{/ch,id}", "/270319070"},
+		{"{/list*}", "/a"},
+		{"{?map*}", "?a=b"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			Execute(ast, &buf, data)
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+var errDigitKey = errors.New("digit in key")
+
+// upperKey decodes keys to upper case, and rejects the keys with digits.
+type upperKey string
+
+func (k *upperKey) UnmarshalText(text []byte) error {
+	if strings.ContainsAny(string(text), "0123456789") {
+		return errDigitKey
+	}
+	*k = upperKey(strings.ToUpper(string(text)))
+	return nil
+}
+
+func TestMapKeys(t *testing.T) {
+	// as decoded by YAML libraries
+	data := map[interface{}]interface{}{
+		"server": map[interface{}]interface{}{
+			"host": "example.com",
+			"port": 8080,
+			1:      "not a string key",
+		},
+		"ports":   map[int]string{80: "http", 443: "https"},
+		"ids":     map[uint8]string{7: "seven"},
+		"headers": map[upperKey]string{"ACCEPT": "text/html"},
+		"ratios":  map[float64]string{1.5: "x"},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{server.host}:{server.port}", "example.com:8080"},
+		{"{/server.1}", "/not%20a%20string%20key"},
+		{"{ports.443}{?ports.80}", "https?80=http"},
+		{"{ports.https}{ids.300}", ""},
+		{"{ids.7}", "seven"},
+		{"{headers.accept}", "text%2Fhtml"},
+		{"{headers.x1}{ratios.1.5}", ""},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			Execute(ast, &buf, data)
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+
+	ast, _ := parser.Parse("/{ports.http}")
+	err := ExecuteWith(ast, &bytes.Buffer{}, data, Options{RequirePathVars: true})
+	var re ResolveError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a ResolveError, got:\n\t%#v", err)
+	}
+
+	for _, tt := range []struct {
+		template string
+		path     []string
+		cause    error
+	}{
+		{"{ports.https}", []string{"ports", "https"}, strconv.ErrSyntax},
+		{"{?ids.300}", []string{"ids", "300"}, strconv.ErrRange},
+		{"{headers.x1}", []string{"headers", "x1"}, errDigitKey},
+		{"{ratios.1}", []string{"ratios", "1"}, errUnsupportedKey},
+	} {
+		t.Run("strict "+tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			err := ExecuteWith(ast, &buf, data, Options{Strict: true})
+			var ke KeyConversionError
+			if !errors.As(err, &ke) || !errors.Is(err, tt.cause) {
+				t.Fatalf("got:\n\t%v\nexpected a KeyConversionError caused by:\n\t%v", err, tt.cause)
+			}
+			if !reflect.DeepEqual(ke.Path, tt.path) {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", ke.Path, tt.path)
+			}
+		})
+	}
+	ast, _ = parser.Parse("{ports.443}{server.missing}{headers.accept}")
+	if err := ExecuteWith(ast, &bytes.Buffer{}, data, Options{Strict: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestYAMLData(t *testing.T) {
+	// as decoded by gopkg.in/yaml.v2 from:
+	//
+	//	service:
+	//	  name: billing api
+	//	  replicas: 3
+	//	  public: true
+	//	  endpoints:
+	//	    v1:
+	//	      path: /v1/invoices
+	//	      methods: [GET, POST]
+	//	  ports:
+	//	    80: http
+	//	    443: https
+	//	  features:
+	//	    true: enabled
+	//	    beta: [exports, webhooks]
+	//	  regions:
+	//	    - name: eu-west
+	//	      weight: 0.5
+	//	    - name: us-east
+	//	      weight: 1.5
+	data := map[interface{}]interface{}{
+		"service": map[interface{}]interface{}{
+			"name":     "billing api",
+			"replicas": 3,
+			"public":   true,
+			"endpoints": map[interface{}]interface{}{
+				"v1": map[interface{}]interface{}{
+					"path":    "/v1/invoices",
+					"methods": []interface{}{"GET", "POST"},
+				},
+			},
+			"ports": map[interface{}]interface{}{
+				80:  "http",
+				443: "https",
+			},
+			"features": map[interface{}]interface{}{
+				true:   "enabled",
+				"beta": []interface{}{"exports", "webhooks"},
+			},
+			"regions": []interface{}{
+				map[interface{}]interface{}{"name": "eu-west", "weight": 0.5},
+				map[interface{}]interface{}{"name": "us-east", "weight": 1.5},
+			},
+		},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"/{service.name}{?service.replicas,service.public}", "/billing%20api?replicas=3&public=true"},
+		{"{+service.endpoints.v1.path}", "/v1/invoices"},
+		{"{?service.endpoints.v1.methods*}", "?methods=GET&methods=POST"},
+		{"{service.ports.443}:{service.ports.80}", "https:http"},
+		{"{?service.ports*}", "?443=https&80=http"},
+		{"{service.features.true}", "enabled"},
+		{"{;service.features*}", ";beta=exports;beta=webhooks;true=enabled"},
+		{"{/service.regions.1.name}", "/us-east"},
+		{"{service.regions.0.weight}", "0.5"},
+		{"{service.ports.8080}{service.features.false}", ""},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			Execute(ast, &buf, data)
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type User struct {
+	uid      int
+	fullName string
+	mail     string
+	Team     *Team
+}
+
+func (u User) ID() int                  { return u.uid }
+func (u *User) Email() string           { return u.mail }
+func (u User) GetName() (string, error) { return u.fullName, nil }
+func (u User) Secret(key string) string { return key }
+
+type Team struct {
+	handle string
+}
+
+var errNoOwner = errors.New("team has no owner")
+
+func (t Team) Slug() string          { return t.handle }
+func (t Team) Owner() (*User, error) { return nil, errNoOwner }
+
+func TestAllowMethods(t *testing.T) {
+	user := User{uid: 42, fullName: "Gontrand", mail: "g@example.com", Team: &Team{"core"}}
+	for _, tt := range []struct {
+		name     string
+		template string
+		data     interface{}
+		opts     Options
+		expected string
+		err      error
+	}{
+		{"disabled", "/{ID}{?name}", user, Options{}, "/", nil},
+		{"value receiver", "/{ID}", user, Options{AllowMethods: true}, "/42", nil},
+		{"pointer receiver", "/{Email}", user, Options{AllowMethods: true}, "/g%40example.com", nil},
+		{"pointer to struct", "/{Email}", &user, Options{AllowMethods: true}, "/g%40example.com", nil},
+		{"get prefix", "{?name}", user, Options{AllowMethods: true}, "?name=Gontrand", nil},
+		{"with arguments", "/{Secret}", user, Options{AllowMethods: true}, "/", nil},
+		{"nested", "/{Team.Slug}", map[string]interface{}{"u": user, "Team": user.Team}, Options{AllowMethods: true}, "/core", nil},
+		{"under a field", "/{user.Team.Slug}/{user.ID}", map[string]interface{}{"user": user}, Options{AllowMethods: true}, "/core/42", nil},
+		{"error", "/{Team.Owner}", user, Options{AllowMethods: true}, "/", nil},
+		{"strict error", "/{Team.Owner.ID}", user, Options{AllowMethods: true, Strict: true}, "/",
+			MethodError{Path: []string{"Team", "Owner"}, Err: errNoOwner}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			err := ExecuteWith(ast, &buf, tt.data, tt.opts)
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSliceIndex(t *testing.T) {
+	var decoded map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"items": [{"name": "first"}, {"name": "second", "tags": ["a", "b"]}],
+		"matrix": [[1, 2], [3, 4]]
+	}`), &decoded)
+	data := map[string]interface{}{
+		"json":  decoded,
+		"list":  []interface{}{"zero", map[string]string{"key": "value"}},
+		"array": [2]Address{{City: "Paris"}, {City: "Lyon"}},
+		"bytes": []byte("abc"),
+		"users": &[]*struct {
+			Name      string
+			Addresses []Address
+		}{{Name: "gontrand", Addresses: []Address{{City: "Paris"}, {City: "Lyon"}}}},
+	}
+	for _, tt := range []struct {
+		template string
+		strict   bool
+		expected string
+		err      error
+	}{
+		{"/{list.0}", false, "/zero", nil},
+		{"/{list.1.key}", false, "/value", nil},
+		{"/{array.1.City}", false, "/Lyon", nil},
+		{"/{json.items.1.name}/{json.items.1.tags.0}", false, "/second/a", nil},
+		{"/{json.matrix.1.0}", false, "/3", nil},
+		{"/{users.0.Name}/{users.0.Addresses.1.City}", false, "/gontrand/Lyon", nil},
+		{"{?json.items.1.tags,json.matrix.0*}", false, "?tags=a,b&0=1&0=2", nil},
+		{"{/json.items.1*}", false, "/name=second/tags=a/tags=b", nil},
+		{"{/json.items.0.name,json.items.2.name}", false, "/first", nil},
+		{"{/list.2,list.x,list.first}", false, "", nil},
+		{"{/bytes.0}", false, "", nil},
+		{"{/list.01}", false, "/key,value", nil},
+		{"{/list.x}", true, "", nil},
+		{"/{json.items.2.name}", true, "/",
+			IndexError{Path: []string{"json", "items", "2"}, Len: 2}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, err := parser.Parse(tt.template)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			var buf bytes.Buffer
+			err = ExecuteWith(ast, &buf, data, Options{Strict: tt.strict})
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", err, tt.err)
+			}
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"io"
+	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// Report describes how the variables of a template resolved during an
+// expansion. Paths are dotted for qualified names, and listed in template
+// order, once per occurrence.
+type Report struct {
+	// Undefined lists the variables that were missing or nil, as well as
+	// the lists and associative arrays with no members, which RFC 6570
+	// considers undefined. They expanded to nothing.
+	Undefined []string
+
+	// Empty lists the variables that were defined as the empty string.
+	// Unlike undefined ones, they still expanded to their operator’s
+	// separators and keys.
+	Empty []string
+}
+
+// ExecuteReport is like Execute, and additionally reports which variables
+// were undefined or empty. The report covers the expressions written before
+// an error, if any.
+func ExecuteReport(ast *parser.Ast, w io.Writer, data interface{}) (Report, error) {
+	var r Report
+	base := newExprWriter(data, Options{})
+	base.report = &r
+	err := execute(ast, w, base)
+	return r, err
+}
+
+func (r *Report) undefined(v *parser.Var) {
+	r.Undefined = append(r.Undefined, strings.Join(v.ID, "."))
+}
+
+func (r *Report) empty(v *parser.Var) {
+	r.Empty = append(r.Empty, strings.Join(v.ID, "."))
+}
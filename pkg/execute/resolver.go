@@ -0,0 +1,45 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import "reflect"
+
+// Resolver is implemented by data that looks up its own variables, instead
+// of being searched by reflection.
+//
+// ResolveURIVar is called with the full path of each variable, split on the
+// dots of qualified names. It returns the value of the variable, and whether
+// it is defined: a nil value reported as defined expands to nothing, but
+// does not fail when Options.RequirePathVars is set. Lists, associative
+// arrays and scalars returned are rendered like any other value.
+type Resolver interface {
+	ResolveURIVar(path []string) (interface{}, bool)
+}
+
+// headResolver adapts a function looking up variables by their head name to
+// a Resolver. The rest of a qualified name is searched by reflection in the
+// value returned.
+type headResolver func(name string) (interface{}, bool)
+
+func (f headResolver) ResolveURIVar(path []string) (interface{}, bool) {
+	x, ok := f(path[0])
+	if !ok || len(path) == 1 {
+		return x, ok
+	}
+	value := reflect.ValueOf(x)
+	dereference(&value)
+	value = findPath(value, path[1:])
+	elem := value
+	dereference(&elem)
+	if !elem.IsValid() || !value.CanInterface() {
+		return nil, false
+	}
+	return value.Interface(), true
+}
@@ -0,0 +1,136 @@
+package execute
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+type resolverFunc func(path []string) (interface{}, bool)
+
+func (f resolverFunc) ResolveURIVar(path []string) (interface{}, bool) {
+	return f(path)
+}
+
+func TestResolver(t *testing.T) {
+	var paths []string
+	resolver := resolverFunc(func(path []string) (interface{}, bool) {
+		paths = append(paths, strings.Join(path, "|"))
+		switch strings.Join(path, ".") {
+		case "session.user.id":
+			return 42, true
+		case "session.roles":
+			return []string{"admin", "dev"}, true
+		case "request.query":
+			return map[string]string{"q": "a b", "lang": "fr"}, true
+		case "cursor":
+			return nil, true
+		}
+		return nil, false
+	})
+	for _, tt := range []struct {
+		template string
+		expected string
+		paths    []string
+	}{
+		{"/users/{session.user.id}", "/users/42", []string{"session|user|id"}},
+		{"{/session.roles}", "/admin,dev", []string{"session|roles"}},
+		{"{/session.roles*}", "/admin/dev", []string{"session|roles"}},
+		{"/search{?request.query*}", "/search?lang=fr&q=a%20b", []string{"request|query"}},
+		{"{?cursor,missing}", "", []string{"cursor", "missing"}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			paths = nil
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			if err := Execute(ast, &out, resolver); err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			if !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("got paths:\n\t%q\nexpected:\n\t%q", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestResolverRequirePathVars(t *testing.T) {
+	resolver := resolverFunc(func(path []string) (interface{}, bool) {
+		if path[0] == "cursor" {
+			return nil, true
+		}
+		return nil, false
+	})
+	opts := Options{RequirePathVars: true}
+
+	ast, _ := parser.Parse("/{cursor}")
+	var out strings.Builder
+	if err := ExecuteWith(ast, &out, resolver, opts); err != nil {
+		t.Fatalf("unexpected error for a defined nil: %v", err)
+	}
+	if got := out.String(); got != "/" {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, "/")
+	}
+
+	ast, _ = parser.Parse("/{missing.id}")
+	err := ExecuteWith(ast, &out, resolver, opts)
+	var re ResolveError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a ResolveError, got:\n\t%#v", err)
+	}
+	if expected := []string{"missing", "id"}; !reflect.DeepEqual(re.Path, expected) {
+		t.Errorf("got path:\n\t%q\nexpected:\n\t%q", re.Path, expected)
+	}
+}
+
+func TestFuncData(t *testing.T) {
+	params := map[string]string{"id": "42", "q": "a b"}
+	strs := func(name string) (string, bool) {
+		s, ok := params[name]
+		return s, ok
+	}
+	ifaces := func(name string) (interface{}, bool) {
+		switch name {
+		case "user":
+			return map[string]interface{}{"id": 7, "tags": []string{"a", "b"}}, true
+		case "null":
+			return nil, true
+		}
+		s, ok := params[name]
+		return s, ok
+	}
+	for _, tt := range []struct {
+		template string
+		data     interface{}
+		expected string
+	}{
+		{"/items/{id}{?q,missing}", strs, "/items/42?q=a%20b"},
+		{"/items/{id.sub}", strs, "/items/"},
+		{"/items/{id}{?q,missing}", ifaces, "/items/42?q=a%20b"},
+		{"/users/{user.id}{?user.tags*}", ifaces, "/users/7?tags=a&tags=b"},
+		{"/users/{user.name}{null}", ifaces, "/users/"},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var out strings.Builder
+			if err := Execute(ast, &out, tt.data); err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+
+	ast, _ := parser.Parse("/users/{user.name}")
+	err := ExecuteWith(ast, &strings.Builder{}, ifaces, Options{RequirePathVars: true})
+	var re ResolveError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a ResolveError, got:\n\t%#v", err)
+	}
+}
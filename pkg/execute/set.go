@@ -0,0 +1,87 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// Set is a group of templates expanded together with the same data, such
+// as the canonical, edit and API URLs of an entity. The zero value is an
+// empty set ready to use.
+type Set struct {
+	names []string
+	asts  []*parser.Ast
+}
+
+// resolved is the value of a variable, as found by the first template of a
+// Set that uses it.
+type resolved struct {
+	value reflect.Value
+	found bool
+}
+
+// Add adds ast to the set under name, replacing the template already added
+// under that name, if any. It returns s, so that calls can be chained.
+func (s *Set) Add(name string, ast *parser.Ast) *Set {
+	for i, n := range s.names {
+		if n == name {
+			s.asts[i] = ast
+			return s
+		}
+	}
+	s.names = append(s.names, name)
+	s.asts = append(s.asts, ast)
+	return s
+}
+
+// ExecuteAll expands each template of the set with data, and returns the
+// outputs by the names the templates were added under. The outputs are the
+// same as those of ExecuteString, but each variable is resolved only once,
+// by the first template that uses it, and the scalars are rendered only
+// once too: the other templates reuse them, and only escape them according
+// to their own operators.
+//
+// The templates are expanded in the order they were added, and the first
+// error stops the expansion.
+func (s *Set) ExecuteAll(data interface{}) (map[string]string, error) {
+	out := make(map[string]string, len(s.names))
+	values := resolvedPool.Get().(map[string]resolved)
+	buf := outputPool.Get().(*bytes.Buffer)
+	defer func() {
+		for k := range values {
+			delete(values, k)
+		}
+		resolvedPool.Put(values)
+		if buf.Cap() <= maxPooledBuffer {
+			buf.Reset()
+			outputPool.Put(buf)
+		}
+	}()
+	for i, ast := range s.asts {
+		buf.Reset()
+		base := newExprWriter(data, Options{})
+		base.resolved = values
+		if err := execute(ast, buf, base); err != nil {
+			return nil, err
+		}
+		out[s.names[i]] = buf.String()
+	}
+	return out, nil
+}
+
+// resolvedPool holds the maps where ExecuteAll keeps the resolved values.
+var resolvedPool = sync.Pool{
+	New: func() interface{} { return make(map[string]resolved) },
+}
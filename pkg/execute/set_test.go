@@ -0,0 +1,89 @@
+package execute
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+type Article struct {
+	ID      int
+	Slug    Reserved
+	Title   string
+	Author  *Address
+	Tags    []string
+	Meta    map[string]interface{}
+	Score   float64
+	Draft   bool
+	Editor  *Address
+	Preview []byte
+}
+
+var setTemplates = map[string]string{
+	"canonical": "/articles/{ID}/{+Slug}{?Draft}",
+	"edit":      "/admin{/ID}/edit{?Title,Tags*,Score}",
+	"api":       "/api/v1/articles/{ID}{?Author.city,Editor.city,Meta*}{#Title}",
+	"preview":   "/preview{/Slug}{;Preview,Score:2,Tags}",
+}
+
+func newArticleSet(t testing.TB) *Set {
+	var set Set
+	for name, template := range setTemplates {
+		ast, err := parser.Parse(template)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		set.Add(name, ast)
+	}
+	return &set
+}
+
+var article = Article{
+	ID:      42,
+	Slug:    "2021/go-templates",
+	Title:   "URI templates in Go",
+	Author:  &Address{City: "Paris"},
+	Tags:    []string{"go", "uri"},
+	Meta:    map[string]interface{}{"lang": "fr", "words": 1200},
+	Score:   4.25,
+	Preview: []byte("a b"),
+}
+
+func TestSetExecuteAll(t *testing.T) {
+	got, err := newArticleSet(t).ExecuteAll(&article)
+	if err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	expected := make(map[string]string)
+	for name, template := range setTemplates {
+		ast, _ := parser.Parse(template)
+		expected[name], _ = ExecuteString(ast, &article)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	set := newArticleSet(b)
+	asts := make([]*parser.Ast, 0, len(setTemplates))
+	for _, template := range setTemplates {
+		ast, _ := parser.Parse(template)
+		asts = append(asts, ast)
+	}
+	b.Run("ExecuteString", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, ast := range asts {
+				ExecuteString(ast, &article)
+			}
+		}
+	})
+	b.Run("ExecuteAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			set.ExecuteAll(&article)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"reflect"
+	"sync"
+)
+
+// structIndex is what expansions need to know about a struct type. It is
+// computed once per type.
+type structIndex struct {
+	names  map[string][]int // the index sequences of the fields by name
+	tags   map[string][]int // the index sequences of the fields by "uri" tag
+	fields []structField    // the exported fields, in declaration order
+}
+
+// structField is an exported field of a struct, as an associative array
+// member.
+type structField struct {
+	key   string
+	index int
+}
+
+// structCache maps struct types to their *structIndex.
+var structCache sync.Map
+
+// indexOf returns the index of the struct type t.
+//
+// Fields of embedded structs are promoted like in Go, and so are tags: the
+// shallowest field wins, and a name or tag found more than once at the same
+// depth is ambiguous, and hides the deeper ones. Names are found in every
+// embedded struct, as with reflect.Type.FieldByName, but tags are not
+// searched in the embedded structs that are themselves tagged. The name a
+// tag gives leaves out its options, like "page" for "page,omitempty", and the
+// fields tagged "-" are left out altogether, like in QueryString.
+func indexOf(t reflect.Type) *structIndex {
+	if s, ok := structCache.Load(t); ok {
+		return s.(*structIndex)
+	}
+	type embedded struct {
+		t     reflect.Type
+		index []int
+		tags  bool // whether the tags of t are searched
+	}
+	s := &structIndex{
+		names: make(map[string][]int),
+		tags:  make(map[string][]int),
+	}
+	visited := make(map[reflect.Type]bool)
+	for current := []embedded{{t, nil, true}}; len(current) > 0; {
+		var next []embedded
+		names := make(map[string][]int)
+		tags := make(map[string][]int)
+		for _, e := range current {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				field := e.t.Field(i)
+				tag, tagged := field.Tag.Lookup("uri")
+				if tag == "-" {
+					continue
+				}
+				tag = tagName(tag)
+				tagged = tagged && tag != ""
+				index := append(append([]int(nil), e.index...), i)
+				promote(names, field.Name, index)
+				if tagged && e.tags {
+					promote(tags, tag, index)
+				}
+				if e.index == nil && field.PkgPath == "" {
+					key := field.Name
+					if tagged {
+						key = tag
+					}
+					s.fields = append(s.fields, structField{key, i})
+				}
+				ft := field.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if field.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{ft, index, e.tags && !tagged})
+				}
+			}
+		}
+		merge(s.names, names)
+		merge(s.tags, tags)
+		current = next
+	}
+	actual, _ := structCache.LoadOrStore(t, s)
+	return actual.(*structIndex)
+}
+
+// promote records index under key for one depth, or nil if it is ambiguous.
+func promote(depth map[string][]int, key string, index []int) {
+	if _, found := depth[key]; found {
+		depth[key] = nil
+	} else {
+		depth[key] = index
+	}
+}
+
+// merge adds the keys of a depth that are not shadowed by shallower ones.
+func merge(all, depth map[string][]int) {
+	for key, index := range depth {
+		if _, shadowed := all[key]; !shadowed {
+			all[key] = index
+		}
+	}
+}
+
+// fieldIndex returns the index sequence of the field named key in the struct
+// type t, or failing that, of the field tagged with `uri:"key"`.
+func fieldIndex(t reflect.Type, key string) []int {
+	s := indexOf(t)
+	if index := s.names[key]; index != nil {
+		return index
+	}
+	return s.tags[key]
+}
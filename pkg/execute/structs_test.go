@@ -0,0 +1,119 @@
+package execute
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+type Listing struct {
+	Page   int    `uri:"page"`
+	Secret string `uri:"-"`
+	Sort   string `uri:"sort,omitempty"`
+	Kept   string
+}
+
+type NamedEmbed struct {
+	Tagged `uri:"t"`
+	Own    string `uri:"own"`
+}
+
+type HiddenEmbed struct {
+	Tagged `uri:"-"`
+	Shown  string
+}
+
+func TestTagLookup(t *testing.T) {
+	data := map[string]interface{}{
+		"l": Listing{Page: 2, Secret: "s", Kept: "k"},
+		"n": NamedEmbed{Tagged{"1", "a"}, "o"},
+		"h": HiddenEmbed{Tagged{"1", "a"}, "v"},
+	}
+	for _, tt := range []struct {
+		template string
+		expected string
+	}{
+		{"{?l*}", "?page=2&sort=&Kept=k"},
+		{"{;l*}", ";page=2;sort;Kept=k"},
+		{"{/l*}", "/page=2/sort=/Kept=k"},
+		{"{?l.page,l.Secret,l.sort,l.Sort}", "?page=2&sort=&Sort="},
+		{"{?h*}", "?Shown=v"},
+		{"{?h.Tagged.ID,h.ID,h.id}", ""},
+		{"{?n.ID,n.Kind,n.t.id,n.own}", "?ID=1&Kind=a&id=1&own=o"},
+		{"{?n.id,n.kind}", ""},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			var buf bytes.Buffer
+			Execute(ast, &buf, data)
+			if got := buf.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+type Wide struct {
+	F00, F01, F02, F03, F04, F05, F06, F07, F08, F09 string
+	F10, F11, F12, F13, F14, F15, F16, F17, F18, F19 string
+	A                                                string `uri:"a"`
+	B                                                string `uri:"b"`
+	C                                                string `uri:"c"`
+	D                                                string `uri:"d"`
+	E                                                string `uri:"e"`
+	F                                                string `uri:"f"`
+	G, H, I, J                                       string
+}
+
+// TestConcurrentFirstUse is meant to be run with -race: the index of a type
+// is built by whichever goroutine needs it first.
+func TestConcurrentFirstUse(t *testing.T) {
+	type Fresh struct {
+		Wide
+		ID string `uri:"id"`
+	}
+	ast, _ := parser.Parse("/{id}/{a}{?b,F19}")
+	data := Fresh{Wide: Wide{A: "a", B: "b", F19: "19"}, ID: "42"}
+	const expected = "/42/a?b=b&F19=19"
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out strings.Builder
+			Execute(ast, &out, data)
+			if got := out.String(); got != expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkWideStruct compares expansions that build the index of the struct
+// type with the ones that find it cached.
+func BenchmarkWideStruct(b *testing.B) {
+	ast, _ := parser.Parse("/{a}/{b}/{c}{?d,e,f}")
+	data := Wide{A: "a", B: "b", C: "c", D: "d", E: "e", F: "f"}
+	typ := reflect.TypeOf(data)
+	var out bytes.Buffer
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			structCache.Delete(typ)
+			out.Reset()
+			Execute(ast, &out, data)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out.Reset()
+			Execute(ast, &out, data)
+		}
+	})
+}
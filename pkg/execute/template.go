@@ -0,0 +1,206 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// step is one part of a compiled template. Steps are in the order of the
+// parts of the Ast, one for each.
+type step struct {
+	literal string       // written as is, if expr is nil
+	sep     bool         // whether the literal is a path separator
+	expr    *parser.Expr // the expression to expand
+	operator
+	fields [][]int // the field index of each variable head, if compiled for a struct
+}
+
+// Template is an Ast compiled into a flat program, for templates that are
+// expanded many times. A Template is never modified once compiled, and its
+// methods can be called from several goroutines at once.
+type Template struct {
+	steps []step
+	ftype reflect.Type
+}
+
+// Compile precomputes everything Execute would derive from ast on each call.
+// Like Execute, it does not modify ast, but the Template shares the
+// variables of its expressions, which must not be modified afterwards.
+func Compile(ast *parser.Ast) (*Template, error) {
+	return CompileFor(ast, nil)
+}
+
+// CompileFor is like Compile, but additionally resolves the struct fields
+// named by the variables when typ is a struct type, or a pointer to one.
+// Executing the template on data of another type still works, but does not
+// benefit from this.
+func CompileFor(ast *parser.Ast, typ reflect.Type) (*Template, error) {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ != nil && typ.Kind() != reflect.Struct {
+		typ = nil
+	}
+	t := &Template{ftype: typ}
+	for _, part := range ast.Parts {
+		if expr, ok := parser.ExprPart(part); ok {
+			part = expr
+		}
+		switch part := part.(type) {
+		case parser.Expr:
+			s := step{expr: &part, operator: operatorOf(part.Op)}
+			if typ != nil {
+				s.fields = make([][]int, len(part.Vars))
+				for i, v := range part.Vars {
+					s.fields[i] = fieldIndex(typ, v.ID[0])
+				}
+			}
+			t.steps = append(t.steps, s)
+		case string:
+			t.steps = append(t.steps, step{literal: parser.EscapeLiteral(part)})
+		case nil:
+			t.steps = append(t.steps, step{literal: "/", sep: true})
+		case *parser.Expr:
+			// a nil expression expands to nothing, like with Execute; its
+			// empty step keeps the steps in line with the parts
+			t.steps = append(t.steps, step{})
+		default:
+			return nil, fmt.Errorf("unexpected part of type %T in the Ast", part)
+		}
+	}
+	return t, nil
+}
+
+// Execute applies the template to the specified data object, and writes the
+// output to w. It behaves exactly like the Execute function.
+func (t *Template) Execute(w io.Writer, data interface{}) error {
+	return t.execute(w, newExprWriter(data, Options{}))
+}
+
+// execute writes the template to w, expanding each expression with a copy
+// of base. It gives the scratch of base back to the pool.
+func (t *Template) execute(w io.Writer, base exprWriter) error {
+	defer putScratch(base.scratch)
+	base.ftype = t.ftype
+	for i := range t.steps {
+		s := &t.steps[i]
+		base.part = i
+		if s.expr == nil {
+			if err := base.writeLiteral(w, s.literal); err != nil {
+				return err
+			}
+			base.explainLiteral(s)
+			continue
+		}
+		base.buf.Reset()
+		ew := base
+		ew.expr = s.expr
+		ew.operator = s.operator
+		ew.fields = s.fields
+		ew.writeExpr()
+		if ew.err != nil {
+			return ew.err
+		}
+		n, err := w.Write(ew.buf.Bytes())
+		base.written += n
+		if err != nil {
+			return WriteError{PartIndex: i, Written: base.written, Expr: s.expr, Err: err}
+		}
+	}
+	return nil
+}
+
+// String applies the template to the specified data object, and returns
+// the output.
+func (t *Template) String(data interface{}) (string, error) {
+	var out strings.Builder
+	err := t.Execute(&out, data)
+	return out.String(), err
+}
+
+// ExpandValues applies the query expressions of the template, those with
+// the '?' and '&' operators, to the specified data object, and returns the
+// parameters they expand to, unescaped, like ExecuteQuery. Literals and the
+// other expressions are ignored, even if they hold a '?' or a '='.
+func (t *Template) ExpandValues(data interface{}) (url.Values, error) {
+	values := make(url.Values)
+	base := newExprWriter(data, Options{})
+	defer putScratch(base.scratch)
+	base.ftype = t.ftype
+	for i := range t.steps {
+		s := &t.steps[i]
+		if s.expr == nil || s.expr.Op != '?' && s.expr.Op != '&' {
+			continue
+		}
+		base.part = i
+		base.buf.Reset()
+		ew := base
+		ew.expr = s.expr
+		ew.operator = s.operator
+		ew.fields = s.fields
+		if err := ew.addQuery(values); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Binding is a template with the values of some of its variables, built
+// one variable at a time.
+type Binding struct {
+	t    *Template
+	vars map[string]interface{}
+}
+
+// With starts a binding of the template, where name has the given value.
+func (t *Template) With(name string, value interface{}) *Binding {
+	return (&Binding{t: t, vars: make(map[string]interface{})}).With(name, value)
+}
+
+// With sets the value of name, replacing any previous one, and returns b.
+func (b *Binding) With(name string, value interface{}) *Binding {
+	b.vars[name] = value
+	return b
+}
+
+// ExpandTo applies the template to the bound variables, and writes the
+// output to w.
+func (b *Binding) ExpandTo(w io.Writer) error {
+	return b.t.Execute(w, b.vars)
+}
+
+// Expand applies the template to the bound variables, and returns the
+// output.
+func (b *Binding) Expand() (string, error) {
+	return b.t.String(b.vars)
+}
+
+// ExpandedLen returns the length in bytes of the output of the template
+// applied to data, without keeping the output around.
+func (t *Template) ExpandedLen(data interface{}) (int, error) {
+	var c countingWriter
+	err := t.Execute(&c, data)
+	return int(c), err
+}
+
+// countingWriter discards what is written to it, but counts the bytes.
+type countingWriter int
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
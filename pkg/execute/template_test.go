@@ -0,0 +1,294 @@
+package execute
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+func compareWithExecute(t *testing.T, fixture Fixture) {
+	for k, e := range fixture {
+		for _, tt := range e.TestCases {
+			input := tt[0].(string)
+			t.Run(k+"/"+input, func(t *testing.T) {
+				ast, err := parser.Parse(input)
+				if err != nil {
+					t.Fatalf("parser error: %v", err)
+				}
+				tpl, err := Compile(ast)
+				if err != nil {
+					t.Fatalf("compile error: %v", err)
+				}
+				var expected strings.Builder
+				Execute(ast, &expected, e.Variables)
+				got, err := tpl.String(e.Variables)
+				if err != nil {
+					t.Errorf("execute error: %v", err)
+				}
+				if got != expected.String() {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected.String())
+				}
+			})
+		}
+	}
+}
+
+func TestCompileSpecExamples(t *testing.T) {
+	compareWithExecute(t, loadFixture(t, "fixtures/spec-examples-by-section.json"))
+}
+
+func TestCompileExtraExamples(t *testing.T) {
+	compareWithExecute(t, loadFixture(t, "fixtures/extra-examples.json"))
+}
+
+type Base struct {
+	ID string
+}
+
+type Route struct {
+	*Base
+	Page    int `uri:"page"`
+	PerPage int `uri:"per_page"`
+	Person  struct {
+		Name string `uri:"name"`
+	} `uri:"person"`
+}
+
+func TestCompileFor(t *testing.T) {
+	ast, _ := parser.Parse("/users/{ID}/{person.name}{?page,per_page,missing}")
+	tpl, err := CompileFor(ast, reflect.TypeOf(&Route{}))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	route := Route{Base: &Base{ID: "270319070"}, Page: 2, PerPage: 50}
+	route.Person.Name = "Gontrand"
+	for _, tt := range []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"struct", route, "/users/270319070/Gontrand?page=2&per_page=50"},
+		{"pointer", &route, "/users/270319070/Gontrand?page=2&per_page=50"},
+		{"nil embedded pointer", Route{Page: 1}, "/users//?page=1&per_page=0"},
+		{"other type", map[string]string{"ID": "42"}, "/users/42/"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tpl.String(tt.data)
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			var expected strings.Builder
+			Execute(ast, &expected, tt.data)
+			if got != expected.String() {
+				t.Errorf("Execute got:\n\t%q\ntemplate got:\n\t%q", expected.String(), got)
+			}
+		})
+	}
+}
+
+func TestEmptyTemplate(t *testing.T) {
+	ast, err := parser.Parse("")
+	if err != nil {
+		t.Fatalf("parser error: %v", err)
+	}
+	tpl, err := Compile(ast)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	for _, data := range []interface{}{
+		nil,
+		map[string]string{"var": "value"},
+		struct{ Var string }{"value"},
+	} {
+		got, err := tpl.String(data)
+		if err != nil {
+			t.Errorf("execute error: %v", err)
+		}
+		if got != "" {
+			t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, "")
+		}
+		if n, _ := tpl.ExpandedLen(data); n != 0 {
+			t.Errorf("got length %d, expected 0", n)
+		}
+	}
+}
+
+func TestBinding(t *testing.T) {
+	ast, _ := parser.Parse("/users/{id}{/name}{?tags*,page}")
+	tpl, _ := Compile(ast)
+
+	got, err := tpl.With("id", 5).With("name", "bob").With("tags", []string{"a", "b"}).Expand()
+	if err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if expected := "/users/5/bob?tags=a&tags=b"; got != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+
+	b := tpl.With("id", 5).With("page", 1).With("id", 6)
+	var out strings.Builder
+	if err := b.ExpandTo(&out); err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	if expected := "/users/6?page=1"; out.String() != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", out.String(), expected)
+	}
+}
+
+func TestExpandedLen(t *testing.T) {
+	data := map[string]interface{}{
+		"var":   "value",
+		"hello": "Hello World!",
+		"list":  []string{"red", "green", "blue"},
+		"keys":  map[string]string{"semi": ";", "dot": ".", "comma": ","},
+		"name":  "Ünïcødé",
+	}
+	for _, template := range []string{
+		"",
+		"/static/path",
+		"{var}",
+		"{hello}/{+hello}{#hello}",
+		"{/list*}{?keys*}",
+		"{;name:3,undefined}{&list}",
+		"{undefined}",
+	} {
+		t.Run(template, func(t *testing.T) {
+			ast, _ := parser.Parse(template)
+			tpl, _ := Compile(ast)
+			expanded, _ := tpl.String(data)
+			got, err := tpl.ExpandedLen(data)
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if got != len(expanded) {
+				t.Errorf("got:\n\t%d\nexpected:\n\t%d", got, len(expanded))
+			}
+		})
+	}
+}
+
+// TestConcurrentTemplate is meant to be run with -race.
+func TestConcurrentTemplate(t *testing.T) {
+	ast, _ := parser.Parse("/users/{ID}/{person.name}{?page,per_page}")
+	tpl, _ := CompileFor(ast, reflect.TypeOf(Route{}))
+	const expected = "/users/270319070/Gontrand?page=2&per_page=50"
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			route := Route{Base: &Base{ID: "270319070"}, Page: 2, PerPage: 50}
+			route.Person.Name = "Gontrand"
+			for i := 0; i < 50; i++ {
+				var got string
+				if g%2 == 0 {
+					got, _ = tpl.String(&route)
+				} else {
+					var out strings.Builder
+					Execute(ast, &out, route)
+					got = out.String()
+				}
+				if got != expected {
+					t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkTemplate(b *testing.B) {
+	ast, _ := parser.Parse("/users/{ID}/{person.name}/posts{?page,per_page}")
+	route := &Route{Base: &Base{ID: "270319070"}, Page: 2, PerPage: 50}
+	route.Person.Name = "Gontrand"
+	tpl, _ := CompileFor(ast, reflect.TypeOf(route))
+
+	b.Run("Execute", func(b *testing.B) {
+		b.ReportAllocs()
+		var out bytes.Buffer
+		for i := 0; i < b.N; i++ {
+			out.Reset()
+			Execute(ast, &out, route)
+		}
+	})
+	b.Run("Template", func(b *testing.B) {
+		b.ReportAllocs()
+		var out bytes.Buffer
+		for i := 0; i < b.N; i++ {
+			out.Reset()
+			tpl.Execute(&out, route)
+		}
+	})
+}
+
+func TestExpandValues(t *testing.T) {
+	ast, _ := parser.Parse("/search/{term}{?a,b*}{&c}#{?a}")
+	tmpl, _ := Compile(ast)
+	for _, tt := range []struct {
+		name     string
+		data     interface{}
+		expected url.Values
+	}{
+		{"all", map[string]interface{}{
+			"term": "x?y=z",
+			"a":    "1 & 2",
+			"b":    map[string]string{"k": "v=w", "l": "+"},
+			"c":    []string{"red", "green"},
+		}, url.Values{"a": {"1 & 2", "1 & 2"}, "k": {"v=w"}, "l": {"+"}, "c": {"red,green"}}},
+		{"exploded list", map[string]interface{}{
+			"b": []string{"x", "y"},
+		}, url.Values{"b": {"x", "y"}}},
+		{"none", map[string]interface{}{"term": "t"}, url.Values{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tmpl.ExpandValues(tt.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	for _, tt := range []struct {
+		template string
+		expected []Step
+	}{
+		{"{?a,b}", []Step{
+			{0, StepSign, "", "?"},
+			{0, StepVariable, "a", "a=1"},
+			{0, StepUndefined, "b", ""},
+		}},
+		{"/x/{y}{?b}{&a,c}", []Step{
+			{0, StepSeparator, "", "/"},
+			{1, StepLiteral, "", "x"},
+			{2, StepSeparator, "", "/"},
+			{3, StepVariable, "y", "a%20b"},
+			{4, StepUndefined, "b", ""},
+			{5, StepSign, "", "&"},
+			{5, StepVariable, "a", "a=1"},
+			{5, StepVariable, "c", "&c="},
+		}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			tmpl, _ := Compile(ast)
+			got := tmpl.Explain(map[string]string{"a": "1", "c": "", "y": "a b"})
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", got, tt.expected)
+			}
+		})
+	}
+}
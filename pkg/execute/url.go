@@ -0,0 +1,306 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package execute
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// ExecuteString applies a parsed uritemplate to the specified data object,
+// and returns the output.
+func ExecuteString(ast *parser.Ast, data interface{}) (string, error) {
+	var out strings.Builder
+	err := Execute(ast, &out, data)
+	return out.String(), err
+}
+
+// outputPool holds the buffers that ExpandToString expands into.
+var outputPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// ExpandToString is like ExecuteString, but expands into a buffer taken from
+// a pool and given back afterwards, so that the returned string is the only
+// allocation that depends on the length of the output. It suits the callers
+// that expand a template once in a while, without keeping a buffer around.
+func ExpandToString(ast *parser.Ast, data interface{}) (string, error) {
+	out := outputPool.Get().(*bytes.Buffer)
+	out.Reset()
+	err := Execute(ast, out, data)
+	s := out.String()
+	if out.Cap() <= maxPooledBuffer {
+		outputPool.Put(out)
+	}
+	return s, err
+}
+
+// Renderer returns an io.WriterTo expanding ast with data each time its
+// WriteTo method is called, for the code that takes one, such as a handler
+// writing the expansion straight to its response. Nothing is resolved
+// before: the errors of the expansion are returned by WriteTo, along with
+// the number of bytes written until then.
+func Renderer(ast *parser.Ast, data interface{}) io.WriterTo {
+	return renderer{ast, data}
+}
+
+type renderer struct {
+	ast  *parser.Ast
+	data interface{}
+}
+
+func (r renderer) WriteTo(w io.Writer) (int64, error) {
+	c := countedWriter{w: w}
+	err := Execute(r.ast, &c, r.data)
+	return c.n, err
+}
+
+// countedWriter counts the bytes successfully written to w.
+type countedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countedWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ExecuteURL applies a parsed uritemplate to the specified data object, and
+// returns the output as a URL, without parsing it again.
+//
+// The query starts at the first '?' and the fragment at the first '#' of
+// the output, which can only come from literals, from the signs of the '?'
+// and '#' operators, and from the values expanded by the '+' and '#'
+// operators, as the others escape them. The escaped forms of the path and
+// fragment are kept in RawPath and RawFragment when they differ from what
+// net/url would write. Only the outputs that begin with a scheme or an
+// authority are handed to url.Parse, up to the query.
+func ExecuteURL(ast *parser.Ast, data interface{}) (*url.URL, error) {
+	var c urlComponents
+	if err := Execute(ast, &c, data); err != nil {
+		return nil, err
+	}
+	path := c.parts[pathComponent].String()
+	u := new(url.URL)
+	if hasSchemeOrAuthority(path) {
+		var err error
+		if u, err = url.Parse(path); err != nil {
+			return nil, err
+		}
+	} else if err := setEscaped(&u.Path, &u.RawPath, path, u.EscapedPath); err != nil {
+		return nil, err
+	}
+	if c.query {
+		u.RawQuery = c.parts[queryComponent].String()
+		u.ForceQuery = u.RawQuery == ""
+	}
+	if c.fragment {
+		fragment := c.parts[fragmentComponent].String()
+		if err := setEscaped(&u.Fragment, &u.RawFragment, fragment, u.EscapedFragment); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// setEscaped sets the decoded field of a URL from its escaped form, and its
+// raw field too if escaped, as computed by the URL, would be different.
+func setEscaped(decoded, raw *string, s string, escaped func() string) error {
+	var err error
+	if *decoded, err = escape.Unescape(s); err != nil {
+		return err
+	}
+	if escaped() != s {
+		*raw = s
+	}
+	return nil
+}
+
+// hasSchemeOrAuthority reports whether path, as written before the query,
+// would be read as something else than a path by url.Parse.
+func hasSchemeOrAuthority(path string) bool {
+	if strings.HasPrefix(path, "//") {
+		return true
+	}
+	colon := strings.IndexByte(path, ':')
+	slash := strings.IndexByte(path, '/')
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+const (
+	pathComponent = iota
+	queryComponent
+	fragmentComponent
+)
+
+// urlComponents is an io.Writer splitting what it is given into the path,
+// query and fragment of a URL.
+type urlComponents struct {
+	current  int
+	parts    [3]strings.Builder
+	query    bool // whether a query was started
+	fragment bool // whether a fragment was started
+}
+
+func (c *urlComponents) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := -1
+		switch c.current {
+		case pathComponent:
+			i = bytes.IndexAny(p, "?#")
+		case queryComponent:
+			i = bytes.IndexByte(p, '#')
+		}
+		if i < 0 {
+			c.parts[c.current].Write(p)
+			break
+		}
+		c.parts[c.current].Write(p[:i])
+		if p[i] == '?' {
+			c.current, c.query = queryComponent, true
+		} else {
+			c.current, c.fragment = fragmentComponent, true
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// ExecuteQuery applies the query expressions of a parsed uritemplate, those
+// with the '?' and '&' operators, to the specified data object, and returns
+// the parameters they expand to, unescaped. The other parts are ignored.
+//
+// Values are rendered like Execute does: exploded lists are repeated keys,
+// other lists are joined with commas, and undefined variables are left out.
+// Each key lists its values in template order. Reserved values are given
+// back as they are too, whatever characters they hold.
+func ExecuteQuery(ast *parser.Ast, data interface{}) (url.Values, error) {
+	values := make(url.Values)
+	base := newExprWriter(data, Options{})
+	defer putScratch(base.scratch)
+	base.escapeAll = true
+	for _, part := range ast.Parts {
+		expr, ok := parser.ExprPart(part)
+		if !ok || expr.Op != '?' && expr.Op != '&' {
+			continue
+		}
+		base.buf.Reset()
+		ew := base
+		ew.expr = &expr
+		ew.operator = operatorOf(expr.Op)
+		if err := ew.addQuery(values); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// addQuery expands a query expression, and adds the parameters it expands
+// to to values.
+func (e *exprWriter) addQuery(values url.Values) error {
+	e.writeExpr()
+	if e.err != nil {
+		return e.err
+	}
+	if e.buf.Len() == 0 {
+		return nil
+	}
+	// every '&', '=', '+' and ';' of the values was escaped, even those of
+	// Reserved values
+	query, err := url.ParseQuery(e.buf.String()[1:])
+	if err != nil {
+		return err
+	}
+	for key, vs := range query {
+		values[key] = append(values[key], vs...)
+	}
+	return nil
+}
+
+// QueryString renders the exported fields of a struct that are not zero as
+// a query string, such as "?page=2&sort=date", escaped like with the '?'
+// operator. Fields are named by their "uri" tag, or else by their "json"
+// tag, or else by their Go name, and the ones tagged "-" are left out.
+// Lists are repeated keys, and associative arrays are exploded.
+//
+// QueryString returns "" if data is not a struct, or if all of its fields
+// are zero.
+func QueryString(data interface{}) string {
+	e := newExprWriter(data, Options{})
+	defer putScratch(e.scratch)
+	if e.data.Kind() != reflect.Struct {
+		return ""
+	}
+	e.expr = &parser.Expr{Op: '?'}
+	e.operator = operatorOf('?')
+	e.buf.WriteByte(e.sign)
+	for _, p := range queryPairs(e.data) {
+		switch {
+		case isList(p.value):
+			for _, item := range listItems(p.value) {
+				e.writePair(p.key, item)
+			}
+		case isAssociative(p.value):
+			for _, q := range associativePairs(p.value) {
+				e.writePair(q.key, q.value)
+			}
+		default:
+			e.writePair(p.key, p.value)
+		}
+	}
+	if e.i == 0 {
+		return ""
+	}
+	return e.buf.String()
+}
+
+// tagName returns the name a struct tag gives to its field, without its
+// options, such as "page" for "page,omitempty".
+func tagName(tag string) string {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// queryPairs lists the fields of the struct value s that QueryString
+// renders.
+func queryPairs(s reflect.Value) (pairs []pair) {
+	t := s.Type()
+	for _, field := range indexOf(t).fields {
+		key := field.key
+		if sf := t.Field(field.index); sf.Tag.Get("uri") == "" {
+			if name := tagName(sf.Tag.Get("json")); name == "-" {
+				continue
+			} else if name != "" {
+				key = name
+			}
+		}
+		elem := s.Field(field.index)
+		if elem.IsZero() {
+			continue
+		}
+		dereference(&elem)
+		if elem.IsValid() && !isOpaque(elem) {
+			pairs = append(pairs, pair{key, elem})
+		}
+	}
+	return
+}
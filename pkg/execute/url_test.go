@@ -0,0 +1,167 @@
+package execute
+
+import (
+	"bytes"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+func TestExecuteURL(t *testing.T) {
+	data := map[string]interface{}{
+		"id":     "42",
+		"name":   "a b/c",
+		"path":   "/foo/bar baz",
+		"query":  "a=1&b=2",
+		"search": "café ?1",
+		"list":   []string{"red", "green"},
+		"host":   "example.com",
+	}
+	for _, tt := range []struct {
+		template string
+		expected url.URL
+	}{
+		{"/users/{id}", url.URL{Path: "/users/42"}},
+		{"/users/{name}", url.URL{Path: "/users/a b/c", RawPath: "/users/a%20b%2Fc"}},
+		{"/users{+path}", url.URL{Path: "/users/foo/bar baz"}},
+		{"/search{?search,list}", url.URL{Path: "/search", RawQuery: "search=caf%C3%A9%20%3F1&list=red,green"}},
+		{"/search?{query}", url.URL{Path: "/search", RawQuery: "a%3D1%26b%3D2"}},
+		{"/search?{+query}#top", url.URL{Path: "/search", RawQuery: "a=1&b=2", Fragment: "top"}},
+		{"/search?", url.URL{Path: "/search", ForceQuery: true}},
+		{"/search{?missing}", url.URL{Path: "/search"}},
+		{"/page{#name}", url.URL{Path: "/page", Fragment: "a b/c"}},
+		{"/page#{name}", url.URL{Path: "/page", Fragment: "a b/c", RawFragment: "a%20b%2Fc"}},
+		{"/page{#search}", url.URL{Path: "/page", Fragment: "café ?1"}},
+		{"/page{?id}{#list}", url.URL{Path: "/page", RawQuery: "id=42", Fragment: "red,green"}},
+		{"{+host}/users/{id}", url.URL{Path: "example.com/users/42"}},
+		{"/files/a%2Fb/{id}", url.URL{Path: "/files/a/b/42", RawPath: "/files/a%2Fb/42"}},
+		{"https:{+host}/users", url.URL{Scheme: "https", Opaque: "example.com/users"}},
+		{"mailto:{id}@{host}?subject={name}", url.URL{Scheme: "mailto", Opaque: "42@example.com", RawQuery: "subject=a%20b%2Fc"}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			u, err := ExecuteURL(ast, data)
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if !reflect.DeepEqual(*u, tt.expected) {
+				t.Errorf("got:\n\t%#v\nexpected:\n\t%#v", *u, tt.expected)
+			}
+			s, _ := ExecuteString(ast, data)
+			if u.String() != s {
+				t.Errorf("got:\n\t%q\nExecuteString got:\n\t%q", u.String(), s)
+			}
+			parsed, _ := url.Parse(s)
+			if !reflect.DeepEqual(u, parsed) {
+				t.Errorf("got:\n\t%#v\nurl.Parse got:\n\t%#v", *u, *parsed)
+			}
+		})
+	}
+}
+
+func TestExecuteQuery(t *testing.T) {
+	data := map[string]interface{}{
+		"id":    "42",
+		"q":     "a+b & c=d",
+		"empty": "",
+		"list":  []string{"red", "green"},
+		"keys":  map[string]string{"semi": ";", "dot": "."},
+		"long":  "Ünïcødé",
+		"raw":   Reserved("a&b=c;d"),
+		"path":  Reserved("a%20b/c+d"),
+	}
+	for _, tt := range []struct {
+		template string
+		expected url.Values
+	}{
+		{"/users/{id}", url.Values{}},
+		{"/search{?q,empty,missing}", url.Values{"q": {"a+b & c=d"}, "empty": {""}}},
+		{"{?list}", url.Values{"list": {"red,green"}}},
+		{"{?list*}", url.Values{"list": {"red", "green"}}},
+		{"{?keys}", url.Values{"keys": {"dot,.,semi,;"}}},
+		{"{?keys*}", url.Values{"dot": {"."}, "semi": {";"}}},
+		{"{?long:3,q:1}", url.Values{"long": {"Ünï"}, "q": {"a"}}},
+		{"/{id}{?list*}{&list,id}{#id}", url.Values{"list": {"red", "green", "red,green"}, "id": {"42"}}},
+		{"{?missing}{&list*}", url.Values{"list": {"red", "green"}}},
+		{"{?raw,path}", url.Values{"raw": {"a&b=c;d"}, "path": {"a%20b/c+d"}}},
+	} {
+		t.Run(tt.template, func(t *testing.T) {
+			ast, _ := parser.Parse(tt.template)
+			got, err := ExecuteQuery(ast, data)
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", got, tt.expected)
+			}
+		})
+	}
+}
+
+type SearchParams struct {
+	Query    string            `uri:"q"`
+	Page     int               `json:"page,omitempty"`
+	PerPage  int               `json:"per_page"`
+	Sort     *string           `json:"sort"`
+	Tags     []string          `uri:"tag"`
+	Filters  map[string]string `uri:"filters"`
+	Internal string            `json:"-"`
+	Hidden   string            `uri:"-"`
+	Exact    bool
+	secret   string
+}
+
+func TestQueryString(t *testing.T) {
+	empty := ""
+	for _, tt := range []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"all zero", SearchParams{}, ""},
+		{"not a struct", map[string]string{"q": "x"}, ""},
+		{"some set", SearchParams{Query: "a b&c", PerPage: 20}, "?q=a%20b%26c&per_page=20"},
+		{"json names", &SearchParams{Page: 3, Exact: true}, "?page=3&Exact=true"},
+		{"pointer to zero", SearchParams{Sort: &empty}, "?sort="},
+		{"composites", SearchParams{Tags: []string{"go", "url"}, Filters: map[string]string{"b": "2", "a": "1"}},
+			"?tag=go&tag=url&a=1&b=2"},
+		{"empty list", SearchParams{Tags: []string{}}, ""},
+		{"left out", SearchParams{Internal: "x", Hidden: "y", secret: "z"}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := QueryString(tt.data); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderer(t *testing.T) {
+	ast, _ := parser.Parse("/users/{id}/posts{?page,tags*}")
+	data := map[string]interface{}{"id": 42, "page": 2, "tags": []string{"a b", "c"}}
+	r := Renderer(ast, data)
+	data["id"] = "gontrand"
+	expected, _ := ExecuteString(ast, data)
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := buf.String(); got != expected {
+		t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+	}
+	if n != int64(len(expected)) {
+		t.Errorf("got:\n\t%d bytes\nexpected:\n\t%d bytes", n, len(expected))
+	}
+	for _, limit := range []int{0, 3, 15, 20} {
+		n, err := r.WriteTo(&failAfter{limit})
+		if err == nil {
+			t.Errorf("%d: expected an error", limit)
+		}
+		if n != int64(limit) {
+			t.Errorf("got:\n\t%d bytes\nexpected:\n\t%d bytes", n, limit)
+		}
+	}
+}
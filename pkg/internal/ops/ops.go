@@ -0,0 +1,34 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+// Package ops holds the operators registered with lexer.RegisterOperator,
+// so that the tests of the packages built on the lexer can undo their
+// registrations.
+package ops
+
+import "sync"
+
+// registered holds the registered operators.
+var registered sync.Map
+
+// Register registers op.
+func Register(op byte) {
+	registered.Store(op, true)
+}
+
+// Unregister undoes the registration of op.
+func Unregister(op byte) {
+	registered.Delete(op)
+}
+
+// IsRegistered reports whether op was registered.
+func IsRegistered(op byte) bool {
+	_, ok := registered.Load(op)
+	return ok
+}
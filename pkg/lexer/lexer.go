@@ -16,6 +16,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strings"
+
+	"github.com/aksamyt/uritemplate/pkg/internal/ops"
 )
 
 // ItemType identifies the type of scanned items.
@@ -31,7 +33,7 @@ const (
 	ItemOp      // expression operator (see [RFC6570] Section 2.2)
 	ItemExplode // explode variable modifier '*'
 	ItemPrefix  // prefix variable modifier ':'
-	ItemLength  // length of prefix, from 0 to 9999
+	ItemLength  // length of prefix, of up to 4 digits by default
 	ItemDot     // variable part separator '.'
 	ItemComma   // variable list separator ','
 	ItemRaw     // raw path component
@@ -40,6 +42,30 @@ const (
 	ItemEOF // got to the end of the input
 )
 
+var itemTypeNames = [...]string{
+	ItemError:   "Error",
+	ItemSep:     "Sep",
+	ItemLacc:    "Lacc",
+	ItemRacc:    "Racc",
+	ItemOp:      "Op",
+	ItemExplode: "Explode",
+	ItemPrefix:  "Prefix",
+	ItemLength:  "Length",
+	ItemDot:     "Dot",
+	ItemComma:   "Comma",
+	ItemRaw:     "Raw",
+	ItemVar:     "Var",
+	ItemEOF:     "EOF",
+}
+
+// String returns the name of the item type, without the Item prefix.
+func (t ItemType) String() string {
+	if t >= 0 && int(t) < len(itemTypeNames) {
+		return itemTypeNames[t]
+	}
+	return fmt.Sprintf("ItemType(%d)", int(t))
+}
+
 // Item represents a lexeme.
 type Item struct {
 	Typ ItemType // type of the item
@@ -81,21 +107,40 @@ func (i Item) String() string {
 }
 
 type lexer struct {
-	input string
-	start int
-	pos   int
-	items chan Item
+	input     string
+	start     int
+	pos       int
+	items     chan Item
+	maxDigits int
+}
+
+// Config holds the settings of a lexer.
+type Config struct {
+	// LengthDigits is the number of digits of prefix lengths beyond which
+	// the length ends, 4 if zero, as RFC 6570 allows lengths up to 9999.
+	// The digits that follow are read as a variable name, which the parser
+	// rejects.
+	LengthDigits int
 }
 
 // Lex scans an input string and returns a stream of items.
 // The last item that will be sent before closing the channel will always be
 // itemEOF or itemError.
 func Lex(input string) chan Item {
+	return Config{}.Lex(input)
+}
+
+// Lex is like the Lex function, but with the settings of c.
+func (c Config) Lex(input string) chan Item {
 	l := &lexer{
-		input: input,
-		start: 0,
-		pos:   0,
-		items: make(chan Item),
+		input:     input,
+		start:     0,
+		pos:       0,
+		items:     make(chan Item),
+		maxDigits: c.LengthDigits,
+	}
+	if l.maxDigits <= 0 {
+		l.maxDigits = 4
 	}
 	go l.run()
 	return l.items
@@ -126,7 +171,7 @@ func (l *lexer) emit(typ ItemType) {
 }
 
 func (l *lexer) emitRaw(s string) {
-	l.items <- Item{ItemRaw, s, l.pos}
+	l.items <- Item{ItemRaw, s, l.start}
 	l.start = l.pos
 }
 
@@ -210,6 +255,28 @@ func lexPercent(l *lexer) stateFn {
 	return lexPath
 }
 
+// ExtensionOps are the characters that can be registered as operators with
+// RegisterOperator: the ones RFC 6570 reserves for future operators, except
+// the comma, the ones it reserves for application extensions, and '~'.
+const ExtensionOps = "=!@|$()~"
+
+// RegisterOperator makes the lexer accept op as an expression operator. It
+// panics if op is not one of ExtensionOps.
+//
+// It is meant to be called during initialization, as registered operators
+// apply to every template lexed afterwards.
+func RegisterOperator(op byte) {
+	if strings.IndexByte(ExtensionOps, op) == -1 {
+		panic(fmt.Sprintf("lexer: cannot register %#U as an operator", op))
+	}
+	ops.Register(op)
+}
+
+// IsExtensionOp reports whether c was registered as an operator.
+func IsExtensionOp(c byte) bool {
+	return ops.IsRegistered(c)
+}
+
 // lexBeginExpr scans an identifier, or an operator if present.
 //
 // - l.pos is after the '{' delimiter
@@ -222,7 +289,7 @@ func lexBeginExpr(l *lexer) stateFn {
 		return l.error(ErrorEmptyExpr())
 	case isVarchar(c):
 		return lexInExpr
-	case strings.IndexByte("+#./;?&", c) != -1:
+	case strings.IndexByte("+#./;?&", c) != -1 || IsExtensionOp(c):
 		l.pos++
 		l.emit(ItemOp)
 		return lexInExpr
@@ -266,12 +333,12 @@ func lexInExpr(l *lexer) stateFn {
 	}
 }
 
-// lexLength scans at most and 4 ascii digits.
+// lexLength scans at most l.maxDigits ascii digits.
 func lexLength(l *lexer) stateFn {
 	for {
 		// l.peek() return (0, false) at l.eof()
 		c, _ := l.peek()
-		if c < '0' || c > '9' || l.pos > l.start+3 {
+		if c < '0' || c > '9' || l.pos-l.start >= l.maxDigits {
 			if l.pos == l.start {
 				return l.error(ErrorExpectedLength())
 			}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"testing"
 	"testing/quick"
+
+	"github.com/aksamyt/uritemplate/pkg/internal/ops"
 )
 
 type lexTest struct {
@@ -90,6 +92,30 @@ func TestStringer(t *testing.T) {
 	}
 }
 
+func TestItemTypeStringer(t *testing.T) {
+	for typ, expected := range map[ItemType]string{
+		ItemError:   "Error",
+		ItemSep:     "Sep",
+		ItemLacc:    "Lacc",
+		ItemRacc:    "Racc",
+		ItemOp:      "Op",
+		ItemExplode: "Explode",
+		ItemPrefix:  "Prefix",
+		ItemLength:  "Length",
+		ItemDot:     "Dot",
+		ItemComma:   "Comma",
+		ItemRaw:     "Raw",
+		ItemVar:     "Var",
+		ItemEOF:     "EOF",
+		-1:          "ItemType(-1)",
+		ItemEOF + 1: "ItemType(13)",
+	} {
+		if got := fmt.Sprint(typ); got != expected {
+			t.Errorf("ItemType(%d).String(): got\n\t%q\nexpected\n\t%q", int(typ), got, expected)
+		}
+	}
+}
+
 func TestSimple(t *testing.T) {
 	for _, tt := range []lexTest{
 		{"empty", "", []Item{tEOF}},
@@ -284,6 +310,50 @@ func TestPrefixOperators(t *testing.T) {
 	}
 }
 
+func TestLengthDigits(t *testing.T) {
+	for _, tt := range []struct {
+		digits int
+		lexTest
+	}{
+		{0, lexTest{"default", "{a:9999}", []Item{tLacc, tVar("a"), tPrefix, tLength("9999"), tRacc, tEOF}}},
+		{0, lexTest{"default over", "{a:50000}", []Item{tLacc, tVar("a"), tPrefix, tLength("5000"), tVar("0"), tRacc, tEOF}}},
+		{5, lexTest{"five", "{a:50000}", []Item{tLacc, tVar("a"), tPrefix, tLength("50000"), tRacc, tEOF}}},
+		{5, lexTest{"five over", "{a:500000}", []Item{tLacc, tVar("a"), tPrefix, tLength("50000"), tVar("0"), tRacc, tEOF}}},
+		{1, lexTest{"one", "{a:3,b:12}", []Item{
+			tLacc, tVar("a"), tPrefix, tLength("3"), tComma,
+			tVar("b"), tPrefix, tLength("1"), tVar("2"), tRacc, tEOF}}},
+	} {
+		items := collect(Config{LengthDigits: tt.digits}.Lex(tt.input))
+		if !equal(items, tt.items) {
+			sayError(t, tt.lexTest, items)
+		}
+	}
+}
+
+func TestRegisterOperator(t *testing.T) {
+	RegisterOperator('~')
+	t.Cleanup(func() { ops.Unregister('~') })
+	for _, tt := range []lexTest{
+		{"registered", "{~a}", []Item{tLacc, tOp("~"), tVar("a"), tRacc, tEOF}},
+		{"not registered", "{$a}", []Item{tLacc, tError(ErrorUnexpected('$'))}},
+	} {
+		items := collect(Lex(tt.input))
+		if !equal(items, tt.items) {
+			sayError(t, tt, items)
+		}
+	}
+	for _, c := range "+#./;?&,*:%{}a_" {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registered %q without panicking", c)
+				}
+			}()
+			RegisterOperator(byte(c))
+		}()
+	}
+}
+
 func TestSuffixOperators(t *testing.T) {
 	for _, tt := range []lexTest{
 		{"explode", "{boom*}", []Item{
@@ -0,0 +1,50 @@
+//go:build go1.18
+// +build go1.18
+
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"/",
+		"a//b/",
+		"/users/{id}{?page,per_page}",
+		"{.ext}{/path*}{;x:3}{#a.b,c:0}",
+		"{var:9999}{+half}{&who,dub}",
+		"a%2fb%20c%25%7B%7d",
+		"caf%C3%A9/%E2%82%AC",
+		"{doubleMod:3*}",
+		"{commaComma,,}",
+		"{dotDot..}",
+		"{noComma:3ohno}",
+		"{big:10000}",
+		"x{&,y}",
+		"{oops",
+		"{!}",
+		"%zz",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		ast, err := Parse(input)
+		if err != nil {
+			return
+		}
+		template := ast.Template()
+		again, err := Parse(template)
+		if err != nil {
+			t.Fatalf("parse error for %q, from %q: %v", template, input, err)
+		}
+		if !reflect.DeepEqual(again, ast) {
+			t.Errorf("got:\n%s\nexpected:\n%s\ninput:\n\t%q", indent(again.String()), indent(ast.String()), input)
+		}
+		if got := again.Template(); got != template {
+			t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, template)
+		}
+	})
+}
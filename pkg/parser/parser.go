@@ -12,10 +12,11 @@ package parser
 
 import (
 	"fmt"
-	"math"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/aksamyt/uritemplate/pkg/lexer"
 )
@@ -27,12 +28,46 @@ type Mod int
 const (
 	// No modifier
 	ModNone Mod = 0
-	// Prefix modifier ':' (real value from (1<<14)+0 to (1<<14)+9999)
+	// Prefix modifier ':' (real value from (1<<14)+0 to (1<<14)+9999, see
+	// PrefixMod for the lengths over 16383 that Config.MaxPrefix allows)
 	ModPrefix Mod = 1 << 14
 	// Explode modifier '*'
 	ModExplode Mod = 1 << 15
 )
 
+// maxPrefixLength is the greatest prefix length a Mod can hold.
+const maxPrefixLength = 1<<28 - 1
+
+// PrefixMod returns the modifier of a prefix of length n, between 0 and
+// 268435455. It is ModPrefix+n for the lengths up to 16383, which fit
+// below ModPrefix. The bits of greater lengths that do not fit are stored
+// above ModExplode, so that the flags keep their values.
+func PrefixMod(n int) Mod {
+	return ModPrefix | Mod(n)&(ModPrefix-1) | Mod(n>>14)<<16
+}
+
+// Length returns the length of a prefix modifier, or 0 if m has none.
+func (m Mod) Length() int {
+	if m&ModPrefix == 0 {
+		return 0
+	}
+	return int(m&(ModPrefix-1)) | int(m>>16)<<14
+}
+
+// String returns the modifier as it would appear in a template: "" for
+// ModNone, ":N" for a prefix of length N, and "*" for explode.
+func (m Mod) String() string {
+	var s strings.Builder
+	if m&ModPrefix != 0 {
+		s.WriteByte(':')
+		s.WriteString(strconv.Itoa(m.Length()))
+	}
+	if m&ModExplode != 0 {
+		s.WriteByte('*')
+	}
+	return s.String()
+}
+
 // Var represents a (possibly qualified) variable with its modifier.
 type Var struct {
 	ID  []string
@@ -57,13 +92,7 @@ func (e Expr) String() string {
 			s.WriteByte(',')
 		}
 		s.WriteString(strings.Join(v.ID, "."))
-		if v.Mod&ModPrefix != 0 {
-			s.WriteByte(':')
-			s.WriteString(strconv.Itoa(int(v.Mod ^ ModPrefix)))
-		}
-		if v.Mod&ModExplode != 0 {
-			s.WriteByte('*')
-		}
+		s.WriteString(v.Mod.String())
 	}
 	s.WriteByte('}')
 	return s.String()
@@ -74,14 +103,40 @@ func (e Expr) String() string {
 // Variables are listed in a separate slice for easy analysis.
 //
 // Parts are stored as a slice of interfaces. Path separators '/' are stored
-// as nil elements, raw parts as strings, and expressions as Expr.
+// as nil elements, raw parts as strings, and expressions as Expr. Asts built
+// by hand may hold expressions as *Expr too, see ExprPart; a nil *Expr holds
+// no expression, and expands to nothing.
+// Consecutive separators are collapsed into one, as in "a//b", unless the
+// template is parsed with KeepSlashes, and except for the two that begin
+// the authority after the scheme of a template starting with one, such as
+// "https://{host}/x": it gives "https:", nil, nil, the expression, nil and
+// "x", so that the URL keeps its meaning. When the authority is empty, the
+// slash that begins the path is kept too, as in "file:///etc/{f}".
+//
+// The %XX sequences of raw parts are decoded if they encode an unreserved
+// character, and kept otherwise, in uppercase, since decoding them could
+// change the meaning of the URL: "a%2fb%7E" gives "a%2Fb~".
 type Ast struct {
 	// Variable names used in the parts.
 	Vars map[string]struct{}
-	// nil, string, or Expr.
+	// nil, string, Expr, or *Expr.
 	Parts []interface{}
 }
 
+// ExprPart returns the expression that part of an Ast holds, if any, either
+// as an Expr, like the parser gives, or as a non-nil *Expr.
+func ExprPart(part interface{}) (Expr, bool) {
+	switch part := part.(type) {
+	case Expr:
+		return part, true
+	case *Expr:
+		if part != nil {
+			return *part, true
+		}
+	}
+	return Expr{}, false
+}
+
 func (t Ast) String() string {
 	vars := []string(nil)
 	for v := range t.Vars {
@@ -95,21 +150,157 @@ func (t Ast) String() string {
 			parts = append(parts, "/")
 		case string:
 			parts = append(parts, fmt.Sprintf("%q", p))
-		case Expr:
-			parts = append(parts, fmt.Sprintf("%v", p))
+		default:
+			if e, ok := ExprPart(p); ok {
+				parts = append(parts, fmt.Sprintf("%v", e))
+			}
 		}
 	}
 	return fmt.Sprintf("VARS: %v\n%v", vars, parts)
 }
 
+// Template returns a template that parses back to t. Literal characters
+// that a template cannot hold as is are percent-encoded, as are '%', '/',
+// '{' and '}', which would otherwise be read as something else.
+//
+// It is the template t was parsed from, up to the case of the %XX
+// sequences, the length of prefixes, and the characters that did not need
+// to be percent-encoded.
+func (t Ast) Template() string {
+	var s strings.Builder
+	for _, p := range t.Parts {
+		switch p := p.(type) {
+		case nil:
+			s.WriteByte('/')
+		case string:
+			s.WriteString(EscapeLiteral(p))
+		default:
+			if e, ok := ExprPart(p); ok {
+				s.WriteString(e.String())
+			}
+		}
+	}
+	return s.String()
+}
+
+// EscapeLiteral percent-encodes the characters of a literal part that the
+// lexer would not read back as themselves: those that a template cannot hold
+// as is, along with '/', '{' and '}', and the '%' that do not begin a %XX
+// sequence. The %XX sequences the parser kept encoded are left as is, so
+// that the literal "a%2Fb" of "a%2Fb" is written back as "a%2Fb", not as a
+// path of two segments. s is returned as is if none of its characters need
+// escaping.
+func EscapeLiteral(s string) string {
+	const upperhex = "0123456789ABCDEF"
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if needsEscape(s, i) {
+			n++
+		}
+	}
+	if n == 0 {
+		return s
+	}
+	t := make([]byte, 0, len(s)+2*n)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if needsEscape(s, i) {
+			t = append(t, '%', upperhex[c>>4], upperhex[c&0xF])
+		} else {
+			t = append(t, c)
+		}
+	}
+	return string(t)
+}
+
+// needsEscape reports whether the character s[i] must be percent-encoded in
+// a literal.
+func needsEscape(s string, i int) bool {
+	c := s[i]
+	if c == '%' {
+		return i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2])
+	}
+	return c <= ' ' || strings.IndexByte("\"'/<>\\^`{|}", c) != -1
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// LiteralPrefix returns the literal text that any expansion of the template
+// begins with, that is the raw parts and separators before the first
+// expression, with their characters escaped by EscapeLiteral like
+// expansions do. complete is true if the whole template is literal.
+func (t Ast) LiteralPrefix() (prefix string, complete bool) {
+	var s strings.Builder
+	for _, p := range t.Parts {
+		switch p := p.(type) {
+		case nil:
+			s.WriteByte('/')
+		case string:
+			s.WriteString(EscapeLiteral(p))
+		default:
+			return s.String(), false
+		}
+	}
+	return s.String(), true
+}
+
+// ReferencedFields returns the names of the variables of the template, dotted
+// if qualified, in the order they first appear, without duplicates. These
+// are the paths an expansion looks up in its data, whatever the data is.
+func (t Ast) ReferencedFields() (names []string) {
+	seen := make(map[string]bool)
+	for _, p := range t.Parts {
+		e, ok := ExprPart(p)
+		if !ok {
+			continue
+		}
+		for _, v := range e.Vars {
+			name := strings.Join(v.ID, ".")
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return
+}
+
+// IsStrictRFC6570 reports whether the template only uses features that any
+// RFC 6570 implementation expands the same way. Qualified variable names are
+// the extension it looks for, see NoQualifiedNames, along with the operators
+// registered with lexer.RegisterOperator and the prefix lengths over 9999,
+// see Config.MaxPrefix.
+func (t Ast) IsStrictRFC6570() bool {
+	for _, p := range t.Parts {
+		if e, ok := ExprPart(p); ok {
+			if lexer.IsExtensionOp(e.Op) {
+				return false
+			}
+			for _, v := range e.Vars {
+				if len(v.ID) > 1 || v.Mod.Length() > defaultMaxPrefix {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
 type stateFn func(*parser) (stateFn, error)
 
 type parser struct {
+	input    string
+	mode     Mode
 	ast      Ast
 	expr     Expr
 	variable Var
 	raw      strings.Builder
 	item     lexer.Item
+
+	lengthPos int // the position of the last prefix length
+	maxPrefix int // the maximum prefix length, see Config.MaxPrefix
 }
 
 func (p *parser) pushRawIfAny() {
@@ -119,12 +310,73 @@ func (p *parser) pushRawIfAny() {
 	}
 }
 
+// pushDecoded adds c, decoded from a %XX sequence, to the raw part. Only
+// the unreserved characters are kept decoded, as the others would not mean
+// the same as their encoding: c is encoded back otherwise, in uppercase.
+func (p *parser) pushDecoded(c byte) {
+	const upperhex = "0123456789ABCDEF"
+	if isUnreserved(c) {
+		p.raw.WriteByte(c)
+		return
+	}
+	p.raw.WriteByte('%')
+	p.raw.WriteByte(upperhex[c>>4])
+	p.raw.WriteByte(upperhex[c&0xF])
+}
+
+// isUnreserved reports whether c is an unreserved character of RFC 3986:
+//
+//	unreserved = ALPHA / DIGIT / "-" / "." / "_" / "~"
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
 func (p *parser) pushSeparator() {
-	if len(p.ast.Parts) == 0 || p.ast.Parts[len(p.ast.Parts)-1] != nil {
+	n := len(p.ast.Parts)
+	if n == 0 || p.ast.Parts[n-1] != nil || p.mode&KeepSlashes != 0 || p.beginsAuthority() {
 		p.ast.Parts = append(p.ast.Parts, nil)
 	}
 }
 
+// beginsAuthority reports whether the separator about to be pushed is the
+// second one after the scheme the template starts with, as in "https://",
+// or the third one, which begins the path when the authority is empty, as
+// in "file:///etc".
+func (p *parser) beginsAuthority() bool {
+	n := len(p.ast.Parts)
+	if n != 2 && n != 3 {
+		return false
+	}
+	for _, part := range p.ast.Parts[1:] {
+		if part != nil {
+			return false
+		}
+	}
+	scheme, ok := p.ast.Parts[0].(string)
+	return ok && isScheme(scheme)
+}
+
+// isScheme reports whether s is a URI scheme followed by its colon, like
+// "https:". RFC 3986 defines schemes as:
+//
+//	scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func isScheme(s string) bool {
+	if len(s) < 2 || s[len(s)-1] != ':' {
+		return false
+	}
+	for i, c := range []byte(s[:len(s)-1]) {
+		alpha := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if i == 0 && !alpha {
+			return false
+		}
+		if !alpha && !('0' <= c && c <= '9') && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *parser) appendVariablePart() {
 	part := p.item.Val
 	if len(p.variable.ID) == 0 {
@@ -147,9 +399,22 @@ func (p *parser) assignOp() {
 	p.expr.Op = p.item.Val[0]
 }
 
-func (p *parser) setVariableLength() {
+func (p *parser) setVariableLength() error {
+	p.lengthPos = p.item.Pos
 	length, _ := strconv.Atoi(p.item.Val)
-	p.variable.Mod = ModPrefix + Mod(length)
+	if length > p.maxPrefix {
+		return p.lengthError()
+	}
+	p.variable.Mod = PrefixMod(length)
+	return nil
+}
+
+// lengthError is the error for a prefix length over the maximum.
+func (p *parser) lengthError() error {
+	if p.maxPrefix != defaultMaxPrefix {
+		return LengthOverMaxError
+	}
+	return LengthOver9999Error
 }
 
 func (p *parser) setVariableExplode() {
@@ -167,23 +432,83 @@ func (p *parser) afterVarOrLengthError() error {
 	if p.variable.Mod&ModPrefix != 0 {
 		firstByte := p.item.Val[0]
 		if firstByte >= '0' && firstByte <= '9' {
-			p.item.Pos -= int(math.Log10(float64(
-				p.variable.Mod^ModPrefix,
-			))) + 1
-			return LengthOver9999Error
+			// point at the whole length, leading zeros included
+			p.item.Pos = p.lengthPos
+			return p.lengthError()
 		}
 	}
 	return AfterVarError
 }
 
+// Mode is a set of flags changing what the parser accepts and produces.
+type Mode uint
+
+const (
+	// NoQualifiedNames rejects dotted variable names such as {user.id}.
+	// RFC 6570 allows dots inside variable names but gives them no meaning,
+	// while this implementation reads them as paths into the data: templates
+	// that must expand the same everywhere should not use them.
+	NoQualifiedNames Mode = 1 << iota
+
+	// KeepSlashes stores each '/' as its own separator, instead of
+	// collapsing consecutive ones, so that "a//b" expands back to "a//b".
+	// URL templates need it for the "//" they may hold, as in the
+	// protocol-relative "//{host}/x".
+	KeepSlashes
+)
+
+// Config holds the settings of a parser.
+type Config struct {
+	Mode Mode
+
+	// MaxPrefix is the maximum length of prefix modifiers, 9999 if zero, as
+	// RFC 6570 requires. Greater values, up to 268435455, let templates
+	// such as "{var:50000}" through, which other implementations reject.
+	MaxPrefix int
+}
+
+// defaultMaxPrefix is the maximum prefix length of RFC 6570.
+const defaultMaxPrefix = 9999
+
 // Parse parses an URI template and returns an Ast or an error detailing what
 // happened.
 func Parse(input string) (*Ast, error) {
+	return Config{}.Parse(input)
+}
+
+// ParseReader parses the URI template read from r until EOF. The template
+// is read whole before being parsed, as the lexer works on strings.
+func ParseReader(r io.Reader) (*Ast, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(b))
+}
+
+// ParseStrict is like Parse, but only accepts the variable names that mean
+// the same for any RFC 6570 implementation. It rejects qualified names.
+func ParseStrict(input string) (*Ast, error) {
+	return Config{Mode: NoQualifiedNames}.Parse(input)
+}
+
+// Parse parses an URI template with the settings of c.
+func (c Config) Parse(input string) (*Ast, error) {
 	p := parser{
-		ast: Ast{Vars: map[string]struct{}{}},
+		input:     input,
+		mode:      c.Mode,
+		maxPrefix: c.MaxPrefix,
+		ast:       Ast{Vars: map[string]struct{}{}},
+	}
+	switch {
+	case p.maxPrefix <= 0:
+		p.maxPrefix = defaultMaxPrefix
+	case p.maxPrefix > maxPrefixLength:
+		p.maxPrefix = maxPrefixLength
 	}
+	lex := lexer.Config{LengthDigits: len(strconv.Itoa(p.maxPrefix))}
 	state, err := pRaw, error(nil)
-	for p.item = range lexer.Lex(input) {
+	for p.item = range lex.Lex(input) {
 		if p.item.Typ == lexer.ItemError {
 			return nil, Error{
 				Input: input,
@@ -192,6 +517,12 @@ func Parse(input string) (*Ast, error) {
 			}
 		}
 		if state, err = state(&p); err != nil {
+			if se, ok := err.(SimpleError); ok {
+				err = PositionedError{
+					Err: se,
+					Pos: utf8.RuneCountInString(input[:p.item.Pos]),
+				}
+			}
 			return nil, Error{
 				Input: input,
 				Pos:   p.item.Pos,
@@ -205,11 +536,22 @@ func Parse(input string) (*Ast, error) {
 	return &p.ast, nil
 }
 
+// Valid reports whether input is a valid URI template, returning the error
+// Parse would return. The empty string is a valid template, without any part.
+func Valid(input string) error {
+	_, err := Parse(input)
+	return err
+}
+
 func pRaw(p *parser) (state stateFn, err error) {
 	state = pRaw
 	switch p.item.Typ {
 	case lexer.ItemRaw:
-		p.raw.WriteString(p.item.Val)
+		if p.input[p.item.Pos] == '%' {
+			p.pushDecoded(p.item.Val[0])
+		} else {
+			p.raw.WriteString(p.item.Val)
+		}
 
 	case lexer.ItemSep:
 		p.pushRawIfAny()
@@ -261,6 +603,9 @@ func pAfterVar(p *parser) (state stateFn, err error) {
 		state = pRaw
 
 	case lexer.ItemDot:
+		if p.mode&NoQualifiedNames != 0 {
+			err = QualifiedNameError
+		}
 		state = pExpr
 
 	case lexer.ItemComma:
@@ -287,8 +632,7 @@ func pAfterVar(p *parser) (state stateFn, err error) {
 
 func pLength(p *parser) (stateFn, error) {
 	if p.item.Typ == lexer.ItemLength {
-		p.setVariableLength()
-		return pAfterVar, nil
+		return pAfterVar, p.setVariableLength()
 	}
 	return nil, UnimplementedError{p.item, "pLength"}
 }
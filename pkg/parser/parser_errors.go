@@ -11,6 +11,7 @@ package parser
 
 import (
 	"fmt"
+	"unicode/utf8"
 
 	"github.com/aksamyt/uritemplate/pkg/lexer"
 )
@@ -20,20 +21,32 @@ import (
 type Error struct {
 	Err   error
 	Input string
-	Pos   int
+	Pos   int // the offset in bytes of the error in Input
 }
 
 func (e Error) Error() string {
+	// the column is counted in runes, so that the caret stays under the
+	// error after non-ASCII characters
+	col := e.Pos
+	if col >= 0 && col <= len(e.Input) {
+		col = utf8.RuneCountInString(e.Input[:col])
+	}
 	return fmt.Sprintf(
 		`error at col %d: %v
 %s
 % *s`,
-		e.Pos+1, e.Err,
+		col+1, e.Err,
 		e.Input,
-		e.Pos+1, "^",
+		col+1, "^",
 	)
 }
 
+// Unwrap returns the error found at Pos, so that errors.Is and errors.As see
+// through an Error.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
 // LexerError wraps a lexer.ItemError.
 type LexerError struct {
 	Item lexer.Item
@@ -55,6 +68,13 @@ const (
 	AfterVarError
 	// LengthOver9999Error is returned when at least five digits are given.
 	LengthOver9999Error
+	// QualifiedNameError is returned for a dotted variable name, when the
+	// NoQualifiedNames mode is set.
+	QualifiedNameError
+	// LengthOverMaxError is returned for a prefix length over the maximum
+	// set by Config.MaxPrefix. LengthOver9999Error is returned instead with
+	// the default maximum.
+	LengthOverMaxError
 )
 
 func (e SimpleError) Error() (what string) {
@@ -67,10 +87,32 @@ func (e SimpleError) Error() (what string) {
 		what = "expected '}', '.', or ','"
 	case LengthOver9999Error:
 		what = "length must be between 0 and 9999"
+	case QualifiedNameError:
+		what = "qualified variable names are not allowed"
+	case LengthOverMaxError:
+		what = "length over the maximum of the parser"
 	}
 	return
 }
 
+// PositionedError is a SimpleError along with where it was found, as an
+// offset in runes from the start of the input. Parse wraps its SimpleErrors
+// in it, inside an Error. It unwraps to its SimpleError, so that
+// errors.Is(err, DoubleModError) still works, and errors.As can retrieve
+// the position.
+type PositionedError struct {
+	Err SimpleError
+	Pos int
+}
+
+func (e PositionedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e PositionedError) Unwrap() error {
+	return e.Err
+}
+
 // UnimplementedError signals an illegal state in the parser.
 //
 // Please open an issue at https://github.com/Aksamyt/uritemplate
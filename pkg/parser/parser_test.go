@@ -3,10 +3,12 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/aksamyt/uritemplate/pkg/internal/ops"
 	"github.com/aksamyt/uritemplate/pkg/lexer"
 )
 
@@ -26,6 +28,52 @@ func mid(s ...string) []string {
 	return s
 }
 
+func TestModStringer(t *testing.T) {
+	for _, tt := range []struct {
+		in       Mod
+		expected string
+	}{
+		{ModNone, ""},
+		{ModPrefix + 0, ":0"},
+		{ModPrefix + 3, ":3"},
+		{ModPrefix + 9999, ":9999"},
+		{ModExplode, "*"},
+		{ModPrefix + 12 | ModExplode, ":12*"},
+		{ModPrefix + 16383, ":16383"},
+		{PrefixMod(16384), ":16384"},
+		{PrefixMod(268435455) | ModExplode, ":268435455*"},
+	} {
+		t.Run(tt.expected, func(t *testing.T) {
+			got := tt.in.String()
+			if got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			got = fmt.Sprintf("%v", Var{ID: mid("x"), Mod: tt.in})
+			if expected := fmt.Sprintf("{[x] %s}", tt.expected); got != expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+			}
+		})
+	}
+}
+
+func TestPrefixMod(t *testing.T) {
+	if ModPrefix != 1<<14 || ModExplode != 1<<15 {
+		t.Fatalf("the flags moved: ModPrefix %d, ModExplode %d", ModPrefix, ModExplode)
+	}
+	for _, n := range []int{0, 3, 9999, 16383, 16384, 50000, 1<<20 + 7, maxPrefixLength} {
+		m := PrefixMod(n)
+		if n < 1<<14 && m != ModPrefix+Mod(n) {
+			t.Errorf("got:\n\t%d\nexpected:\n\t%d", m, ModPrefix+Mod(n))
+		}
+		if m&ModExplode != 0 || (m|ModExplode).Length() != n || m.Length() != n {
+			t.Errorf("got:\n\t%d\nexpected:\n\t%d", m.Length(), n)
+		}
+	}
+	if got := ModExplode.Length(); got != 0 {
+		t.Errorf("got:\n\t%d\nexpected:\n\t0", got)
+	}
+}
+
 func TestExprStringer(t *testing.T) {
 	for _, tt := range []struct {
 		in       Expr
@@ -70,11 +118,152 @@ func TestAstStringer(t *testing.T) {
 	}
 }
 
+func TestLiteralPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		prefix   string
+		complete bool
+	}{
+		{"/users/{id}", "/users/", false},
+		{"/users/{id}/posts", "/users/", false},
+		{"/static/path", "/static/path", true},
+		{"{id}/users", "", false},
+		{"/a%2Fb%20c/{id}", "/a%2Fb%20c/", false},
+		{"", "", true},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			ast, _ := Parse(tt.in)
+			prefix, complete := ast.LiteralPrefix()
+			if prefix != tt.prefix || complete != tt.complete {
+				t.Errorf("got:\n\t%q, %v\nexpected:\n\t%q, %v", prefix, complete, tt.prefix, tt.complete)
+			}
+		})
+	}
+}
+
+func TestReferencedFields(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		expected []string
+	}{
+		{"/static/path", nil},
+		{"/users/{id}{?page,per_page}", []string{"id", "page", "per_page"}},
+		{"/{user.id}/{user.name:3}{?user.id,id,user*}", []string{"user.id", "user.name", "id", "user"}},
+		{"{a.b.c}{/a.b}{#a}", []string{"a.b.c", "a.b", "a"}},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			ast, _ := Parse(tt.in)
+			if got := ast.ReferencedFields(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPointerParts(t *testing.T) {
+	for _, in := range []string{
+		"/static/path",
+		"/users/{id}{?page,per_page}",
+		"{.ext}{/path*}{;x:3}{#a.b,c:0}",
+		"/{user.id}/{user.name:3}",
+	} {
+		t.Run(in, func(t *testing.T) {
+			ast, err := Parse(in)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			ptr := Ast{Vars: ast.Vars}
+			for _, p := range ast.Parts {
+				if e, ok := p.(Expr); ok {
+					p = &e
+				}
+				ptr.Parts = append(ptr.Parts, p)
+			}
+			if got, expected := ptr.Template(), ast.Template(); got != expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+			}
+			if got, expected := ptr.ReferencedFields(), ast.ReferencedFields(); !reflect.DeepEqual(got, expected) {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, expected)
+			}
+			if got, expected := ptr.IsStrictRFC6570(), ast.IsStrictRFC6570(); got != expected {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", got, expected)
+			}
+		})
+	}
+	if _, ok := ExprPart((*Expr)(nil)); ok {
+		t.Errorf("got:\n\t%v\nexpected:\n\t%v", ok, false)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		expected string
+	}{
+		{"", ""},
+		{"/users/{id}{?page,per_page}", "/users/{id}{?page,per_page}"},
+		{"{.ext}{/path*}{;x:3}{#a.b,c:0}", "{.ext}{/path*}{;x:3}{#a.b,c:0}"},
+		{"{a:031}", "{a:31}"},
+		{"a//b/", "a/b/"},
+		{"https://{host}/x", "https://{host}/x"},
+		{"a%2fb%20c%25%7B%7d", "a%2Fb%20c%25%7B%7D"},
+		{"caf%C3%A9/%E2%82%AC", "caf%C3%A9/%E2%82%AC"},
+		{"%3f%23%2d%7E", "%3F%23-~"},
+		{"%22%27%3C%3E%5C%5E%60%7C%00", "%22%27%3C%3E%5C%5E%60%7C%00"},
+		{"~!$&()*+,;=:@[]?#.-_", "~!$&()*+,;=:@[]?#.-_"},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			ast, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			got := ast.Template()
+			if got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+			again, err := Parse(got)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if !reflect.DeepEqual(again, ast) {
+				t.Errorf("got:\n%s\nexpected:\n%s", indent(again.String()), indent(ast.String()))
+			}
+		})
+	}
+}
+
+func TestIsStrictRFC6570(t *testing.T) {
+	lexer.RegisterOperator('$')
+	t.Cleanup(func() { ops.Unregister('$') })
+	for _, tt := range []struct {
+		in       string
+		expected bool
+	}{
+		{"", true},
+		{"/static", true},
+		{"/users/{id}{?page,per_page}", true},
+		{"{.ext}{/path*}{;x:3}", true},
+		{"/users/{user.id}", false},
+		{"/users/{id}{?filter.name}", false},
+		{"/users/{$id}", false},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			ast, _ := Parse(tt.in)
+			if got := ast.IsStrictRFC6570(); got != tt.expected {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestAst(t *testing.T) {
 	for _, tt := range []struct {
 		in       string
 		expected Ast
 	}{
+		{"", Ast{
+			Vars: mv(),
+		}},
 		{"hello/world", Ast{
 			Vars:  mv(),
 			Parts: []interface{}{"hello", nil, "world"},
@@ -83,6 +272,60 @@ func TestAst(t *testing.T) {
 			Vars:  mv(),
 			Parts: []interface{}{"hello", nil, "world"},
 		}},
+		{"https://{host}/x", Ast{
+			Vars: mv("host"),
+			Parts: []interface{}{
+				"https:",
+				nil,
+				nil,
+				Expr{Vars: []Var{{ID: mid("host")}}},
+				nil,
+				"x",
+			},
+		}},
+		{"svn+ssh:///repo//trunk", Ast{
+			Vars:  mv(),
+			Parts: []interface{}{"svn+ssh:", nil, nil, nil, "repo", nil, "trunk"},
+		}},
+		{"file:///etc/{f}", Ast{
+			Vars: mv("f"),
+			Parts: []interface{}{
+				"file:",
+				nil,
+				nil,
+				nil,
+				"etc",
+				nil,
+				Expr{Vars: []Var{{ID: mid("f")}}},
+			},
+		}},
+		{"file:////etc", Ast{
+			Vars:  mv(),
+			Parts: []interface{}{"file:", nil, nil, nil, "etc"},
+		}},
+		{"a%2fb%7E/%3F", Ast{
+			Vars:  mv(),
+			Parts: []interface{}{"a%2Fb~", nil, "%3F"},
+		}},
+		{"1a://b", Ast{
+			Vars:  mv(),
+			Parts: []interface{}{"1a:", nil, "b"},
+		}},
+		{"{scheme}://b/c:/d://e", Ast{
+			Vars: mv("scheme"),
+			Parts: []interface{}{
+				Expr{Vars: []Var{{ID: mid("scheme")}}},
+				":",
+				nil,
+				"b",
+				nil,
+				"c:",
+				nil,
+				"d:",
+				nil,
+				"e",
+			},
+		}},
 		{"{var}", Ast{
 			Vars: mv("var"),
 			Parts: []interface{}{
@@ -465,6 +708,13 @@ func TestErrors(t *testing.T) {
 		{Input: "{noComma*ohno}", Pos: 9, Err: AfterVarError},
 		{Input: "{noComma:3ohno}", Pos: 10, Err: AfterVarError},
 		{Input: "{big:10000}", Pos: 5, Err: LengthOver9999Error},
+		{Input: "{zero:00000}", Pos: 6, Err: LengthOver9999Error},
+		{Input: "{+}", Pos: 2, Err: ExpectedVarError},
+		{Input: "{#}", Pos: 2, Err: ExpectedVarError},
+		{Input: "{?,}", Pos: 2, Err: ExpectedVarError},
+		{Input: "{+,}", Pos: 2, Err: ExpectedVarError},
+		{Input: "{/.}", Pos: 2, Err: ExpectedVarError},
+		{Input: "x{&,y}", Pos: 3, Err: ExpectedVarError},
 	} {
 		_, got := Parse(expected.Input)
 		if got == nil {
@@ -475,6 +725,237 @@ func TestErrors(t *testing.T) {
 	}
 }
 
+func TestPositionedError(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		err   SimpleError
+		pos   int
+	}{
+		{"{doubleMod:3*}", DoubleModError, 12},
+		{"x{&,y}", ExpectedVarError, 3},
+		{"/café/{big:10000}", LengthOver9999Error, 11},
+		{"ü{o:3ohno}", AfterVarError, 5},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got:\n\t%#v\nexpected to wrap:\n\t%v", err, tt.err)
+			}
+			var pe PositionedError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected a PositionedError, got:\n\t%#v", err)
+			}
+			if pe.Pos != tt.pos {
+				t.Errorf("got:\n\t%d\nexpected:\n\t%d", pe.Pos, tt.pos)
+			}
+		})
+	}
+	_, err := Parse("{oops")
+	if pe := (PositionedError{}); errors.As(err, &pe) {
+		t.Errorf("got a PositionedError for a lexer error:\n\t%#v", pe)
+	}
+}
+
+// TestLengthCaret checks that the caret of too long prefix lengths points
+// at their first digit, whatever their digits.
+func TestLengthCaret(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		caret string
+	}{
+		{"{a:10000}", "   ^"},
+		{"{a:99999}", "   ^"},
+		{"{a:00000}", "   ^"},
+		{"{a:0000000001}", "   ^"},
+		{"{a:12345678901234567890}", "   ^"},
+		{"{x,long:123456}", "        ^"},
+		{"/{a:9999}/{b:1000}{?c:100000}", "                      ^"},
+		{"é{a:99999}", "    ^"},
+		{"/café/€{b:12345}", "          ^"},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if !errors.Is(err, LengthOver9999Error) {
+				t.Fatalf("got:\n\t%#v\nexpected to wrap:\n\t%v", err, LengthOver9999Error)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if got := lines[len(lines)-1]; got != tt.caret {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.caret)
+			}
+		})
+	}
+	for _, tt := range []struct {
+		input string
+		mod   Mod
+	}{
+		{"{a:0}", ModPrefix + 0},
+		{"{a:0000}", ModPrefix + 0},
+		{"{a:1000}", ModPrefix + 1000},
+		{"{a:9999}", ModPrefix + 9999},
+	} {
+		ast, err := Parse(tt.input)
+		if err != nil {
+			t.Errorf("parse error: %v", err)
+			continue
+		}
+		if got := ast.Parts[0].(Expr).Vars[0].Mod; got != tt.mod {
+			t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.mod)
+		}
+	}
+}
+
+func TestMaxPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		max   int
+		mod   Mod
+		err   error
+		pos   int
+	}{
+		{"{var:50000}", 0, 0, LengthOver9999Error, 5},
+		{"{var:10000}", 9999, 0, LengthOver9999Error, 5},
+		{"{var:9999}", 0, ModPrefix + 9999, nil, 0},
+		{"{var:50000}", 50000, PrefixMod(50000), nil, 0},
+		{"{var:50000}", 99999, PrefixMod(50000), nil, 0},
+		{"{var:00042}", 50000, ModPrefix + 42, nil, 0},
+		{"{var:50001}", 50000, 0, LengthOverMaxError, 5},
+		{"{x,var:500000}", 50000, 0, LengthOverMaxError, 7},
+		{"{var:100}", 99, 0, LengthOverMaxError, 5},
+		{"{var:99}", 99, ModPrefix + 99, nil, 0},
+		{"{var:268435455}", 1 << 40, PrefixMod(268435455), nil, 0},
+	} {
+		t.Run(fmt.Sprint(tt.input, tt.max), func(t *testing.T) {
+			ast, err := Config{MaxPrefix: tt.max}.Parse(tt.input)
+			if tt.err != nil {
+				var pe PositionedError
+				if !errors.Is(err, tt.err) || !errors.As(err, &pe) || pe.Pos != tt.pos {
+					t.Fatalf("got:\n\t%#v\nexpected:\n\t%v at %d", err, tt.err, tt.pos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			v := ast.Parts[0].(Expr).Vars
+			if got := v[len(v)-1].Mod; got != tt.mod {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.mod)
+			}
+			if ast.IsStrictRFC6570() != (tt.mod.Length() <= 9999) {
+				t.Errorf("got:\n\t%v\nexpected:\n\t%v", ast.IsStrictRFC6570(), !ast.IsStrictRFC6570())
+			}
+			if got := ast.Template(); got != tt.input && tt.input != "{var:00042}" {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, input := range []string{"", "/", "a{b}c", "{?x,y}"} {
+		if err := Valid(input); err != nil {
+			t.Errorf("got:\n\t%v\nexpected no error\ninput:\n\t%q", err, input)
+		}
+	}
+	for _, input := range []string{"{", "{}", "a{?,}"} {
+		if err := Valid(input); err == nil {
+			t.Errorf("got no error, input:\n\t%q", input)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestParseReader(t *testing.T) {
+	const template = "/users/{id}{?page,per_page}"
+	expected, _ := Parse(template)
+	got, err := ParseReader(strings.NewReader(template))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got:\n\t%v\nexpected:\n\t%v", got, expected)
+	}
+
+	_, err = ParseReader(strings.NewReader("{oops"))
+	if pe := (Error{}); !errors.As(err, &pe) {
+		t.Errorf("expected an Error, got:\n\t%#v", err)
+	}
+	_, err = ParseReader(failingReader{})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got:\n\t%#v\nexpected:\n\t%#v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	for _, input := range []string{"{foo}", "{foo,bar}", "{.foo}", "a.b/{c}.d"} {
+		if _, err := ParseStrict(input); err != nil {
+			t.Errorf("got:\n\t%v\nexpected no error\ninput:\n\t%q", err, input)
+		}
+	}
+	for _, expected := range []Error{
+		{Input: "{foo.bar}", Pos: 4, Err: QualifiedNameError},
+		{Input: "{.foo,bar.baz:3}", Pos: 9, Err: QualifiedNameError},
+	} {
+		if _, err := Parse(expected.Input); err != nil {
+			t.Errorf("Parse got:\n\t%v\nexpected no error\ninput:\n\t%q", err, expected.Input)
+		}
+		_, got := ParseStrict(expected.Input)
+		if got == nil {
+			t.Errorf("got no error, expected:\n\t%#v\ninput:\n\t%q", expected, expected.Input)
+		} else if got.Error() != expected.Error() {
+			t.Errorf("got:\n\t%#v\nexpected:\n\t%#v\ninput:\n\t%q", got, expected, expected.Input)
+		}
+	}
+}
+
+func TestKeepSlashes(t *testing.T) {
+	for _, tt := range []struct {
+		in        string
+		collapsed []interface{}
+		kept      []interface{}
+	}{
+		{"a//b", []interface{}{"a", nil, "b"}, []interface{}{"a", nil, nil, "b"}},
+		{"//{host}/x", []interface{}{nil, Expr{Vars: []Var{{ID: mid("host")}}}, nil, "x"},
+			[]interface{}{nil, nil, Expr{Vars: []Var{{ID: mid("host")}}}, nil, "x"}},
+		{"a///", []interface{}{"a", nil}, []interface{}{"a", nil, nil, nil}},
+		{"https://a//b", []interface{}{"https:", nil, nil, "a", nil, "b"},
+			[]interface{}{"https:", nil, nil, "a", nil, nil, "b"}},
+	} {
+		t.Run(tt.in, func(t *testing.T) {
+			for _, mode := range []struct {
+				mode     Mode
+				expected []interface{}
+			}{
+				{0, tt.collapsed},
+				{KeepSlashes, tt.kept},
+			} {
+				got, err := Config{Mode: mode.mode}.Parse(tt.in)
+				if err != nil {
+					t.Fatalf("error:\n%v", err)
+				}
+				if !reflect.DeepEqual(got.Parts, mode.expected) {
+					t.Errorf("mode %d got:\n\t%#v\nexpected:\n\t%#v", mode.mode, got.Parts, mode.expected)
+				}
+				if mode.mode == KeepSlashes && got.Template() != tt.in {
+					t.Errorf("got template:\n\t%q\nexpected:\n\t%q", got.Template(), tt.in)
+				}
+			}
+		})
+	}
+}
+
+func TestOperatorOnlyCaret(t *testing.T) {
+	_, err := Parse("a{?,}")
+	expected := "error at col 4: expected variable\na{?,}\n   ^"
+	if err == nil || err.Error() != expected {
+		t.Errorf("got:\n%v\nexpected:\n%s", err, expected)
+	}
+}
+
 func TestEveryStateCheckUnimplemented(t *testing.T) {
 	// lexer.ItemError should always be unimplemented by every stateFn
 	p := parser{item: lexer.Item{Typ: lexer.ItemError}}
@@ -0,0 +1,45 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+// Package testutil provides helpers for the tests of code using uritemplate.
+package testutil
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Diff returns an empty string if got and want are equal. Otherwise, it
+// shows both strings, one above the other, with a caret under the first
+// character where they differ, like parser.Error does.
+func Diff(got, want string) string {
+	if got == want {
+		return ""
+	}
+	i := 0
+	for i < len(got) && i < len(want) && got[i] == want[i] {
+		i++
+	}
+	// back to the start of the character, if it is multibyte
+	for i > 0 && (i < len(got) && !utf8.RuneStart(got[i]) ||
+		i < len(want) && !utf8.RuneStart(want[i])) {
+		i--
+	}
+	col := utf8.RuneCountInString(got[:i])
+	return fmt.Sprintf(
+		`diff at col %d:
+got:  %s
+want: %s
+% *s`,
+		col+1,
+		got,
+		want,
+		len("want: ")+col+1, "^",
+	)
+}
@@ -0,0 +1,26 @@
+package testutil
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	for _, tt := range []struct {
+		got, want string
+		expected  string
+	}{
+		{"/users/42", "/users/42", ""},
+		{"/users/42", "/users/43",
+			"diff at col 9:\ngot:  /users/42\nwant: /users/43\n              ^"},
+		{"/users", "/users/42",
+			"diff at col 7:\ngot:  /users\nwant: /users/42\n            ^"},
+		{"", "x",
+			"diff at col 1:\ngot:  \nwant: x\n      ^"},
+		{"/café", "/cafè",
+			"diff at col 5:\ngot:  /café\nwant: /cafè\n          ^"},
+	} {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := Diff(tt.got, tt.want); got != tt.expected {
+				t.Errorf("got:\n%s\nexpected:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+package uritemplate
+
+import (
+	"io"
+	"sync"
+
+	"github.com/aksamyt/uritemplate/pkg/escape"
+	"github.com/aksamyt/uritemplate/pkg/execute"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+// Template is a parsed URI template, ready to be expanded. It is meant to
+// be parsed once, at startup, and expanded for each request: a Template is
+// never modified once parsed, and can be expanded from several goroutines
+// at once.
+type Template struct {
+	ast      *parser.Ast
+	compiled *execute.Template
+	once     sync.Once        // compiles matcher on the first match
+	matcher  *execute.Matcher // reads the values back from URIs
+}
+
+// Parse parses a URI template. The error is a parser.Error, showing where
+// the template is invalid.
+func Parse(template string) (*Template, error) {
+	ast, err := parser.Parse(template)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := execute.Compile(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{ast: ast, compiled: compiled}, nil
+}
+
+// MustParse is like Parse, but panics if the template is invalid. It is
+// meant for templates known at compile time, in package variables.
+func MustParse(template string) *Template {
+	t, err := Parse(template)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Ast returns the parsed template, for use with the functions of the
+// execute package. It must not be modified.
+func (t *Template) Ast() *parser.Ast {
+	return t.ast
+}
+
+// Expand applies the template to the specified data object, and returns the
+// output. data is given to execute.Execute, which documents what it can be.
+func (t *Template) Expand(data interface{}) (string, error) {
+	return t.compiled.String(data)
+}
+
+// ExpandTo is like Expand, but writes the output to w.
+func (t *Template) ExpandTo(w io.Writer, data interface{}) error {
+	return t.compiled.Execute(w, data)
+}
+
+// Match reads the values of the variables back from uri, an expansion of
+// the template, and reports whether uri matches it. See execute.Matcher for
+// what can be read back.
+func (t *Template) Match(uri string) (map[string]string, bool) {
+	return t.MatchWith(uri, nil)
+}
+
+// MatchWith is like Match, but decodes the values of the expressions with
+// the operator op with decoders[op], if it is set, like
+// execute.Matcher.MatchWith.
+func (t *Template) MatchWith(uri string, decoders map[byte]escape.Decoder) (map[string]string, bool) {
+	t.once.Do(func() { t.matcher = execute.CompileMatcher(t.ast) })
+	return t.matcher.MatchWith(uri, decoders)
+}
+
+// String returns the template, as given to Parse up to its percent-encoded
+// characters.
+func (t *Template) String() string {
+	return t.ast.Template()
+}
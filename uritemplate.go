@@ -0,0 +1,28 @@
+/*
+  This file is part of the uritemplate project.
+  Copyright (C) 2021 Alexandre Szymocha (@Aksamyt).
+
+  This Source Code Form is subject to the terms of the Mozilla Public
+  License, v. 2.0. If a copy of the MPL was not distributed with this
+  file, You can obtain one at http://mozilla.org/MPL/2.0/.
+*/
+
+// Package uritemplate implements URI templates as specified by RFC6570.
+//
+// The heavy lifting is done by the packages under pkg/; this package holds
+// Template, which ties parsing and expansion together, and the types meant
+// to be used directly in the data given to templates.
+//
+// See https://tools.ietf.org/html/rfc6570 for the complete specification.
+package uritemplate
+
+// Rune is a character meant to be expanded as itself.
+//
+// A bare rune is an int32, which is expanded as its numeric code point.
+// Wrapping it in a Rune expands it as the character instead.
+type Rune rune
+
+// String returns the character as a string.
+func (r Rune) String() string {
+	return string(r)
+}
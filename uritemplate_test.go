@@ -0,0 +1,32 @@
+package uritemplate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aksamyt/uritemplate/pkg/execute"
+	"github.com/aksamyt/uritemplate/pkg/parser"
+)
+
+func TestRune(t *testing.T) {
+	ast, _ := parser.Parse("{ch}{?ch}")
+	for _, tt := range []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"bare rune", map[string]interface{}{"ch": 'A'}, "65?ch=65"},
+		{"Rune", map[string]interface{}{"ch": Rune('A')}, "A?ch=A"},
+		{"escaped Rune", map[string]interface{}{"ch": Rune('é')}, "%C3%A9?ch=%C3%A9"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := execute.Execute(ast, &out, tt.data); err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got:\n\t%q\nexpected:\n\t%q", got, tt.expected)
+			}
+		})
+	}
+}